@@ -3,8 +3,8 @@ package main
 import (
 	"os"
 
-	// import _ to register the mint plugin to apptx
-	_ "github.com/tendermint/basecoin-examples/mintcoin/commands"
+	// importing registers the mint plugin to apptx
+	mcmd "github.com/tendermint/basecoin-examples/mintcoin/commands"
 	"github.com/tendermint/basecoin/cmd/commands"
 	"github.com/urfave/cli"
 )
@@ -19,6 +19,8 @@ func main() {
 		commands.TxCmd,
 		commands.QueryCmd,
 		commands.AccountCmd,
+		mcmd.IssuersCmd,
+		mcmd.SupplyCmd,
 	}
 	app.Run(os.Args)
 }
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tendermint/basecoin-examples/mintcoin"
+	bcmd "github.com/tendermint/basecoin/cmd/commands"
+)
+
+// creditRow is one line of a credits file, in json:
+// [{"address": "D397BC...", "amount": "10BTC,5cosmo"}, ...]
+// or csv, with the amount quoted if it has several coins:
+// D397BC...,"10BTC,5cosmo"
+type creditRow struct {
+	Address string `json:"address"`
+	Amount  string `json:"amount"`
+}
+
+// readCreditsFile parses a json (by extension) or csv credits file,
+// validating every row, with a 20 byte address, before returning
+func readCreditsFile(path string) (mintcoin.Credits, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []creditRow
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.NewDecoder(f).Decode(&rows)
+	} else {
+		rows, err = readCreditsCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read %s: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("No credits in %s", path)
+	}
+
+	credits := make(mintcoin.Credits, len(rows))
+	for i, row := range rows {
+		credits[i], err = parseCredit(row.Address, row.Amount)
+		if err == nil && len(credits[i].Addr) != 20 {
+			err = fmt.Errorf("Address must be 20 bytes: %s", row.Address)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Row %d: %v", i+1, err)
+		}
+	}
+	return credits, nil
+}
+
+func readCreditsCSV(r io.Reader) ([]creditRow, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]creditRow, len(records))
+	for i, rec := range records {
+		rows[i] = creditRow{Address: rec[0], Amount: rec[1]}
+	}
+	return rows, nil
+}
+
+// parseCredit makes sure the address and amount are something we can mint
+func parseCredit(addrHex, amount string) (mintcoin.Credit, error) {
+	addr, err := hex.DecodeString(bcmd.StripHex(strings.TrimSpace(addrHex)))
+	if err != nil {
+		return mintcoin.Credit{}, fmt.Errorf("Address is invalid hex: %v", err)
+	}
+
+	coins, err := bcmd.ParseCoins(amount)
+	if err != nil {
+		return mintcoin.Credit{}, err
+	}
+	if !coins.IsValid() || !coins.IsPositive() {
+		return mintcoin.Credit{}, fmt.Errorf("Amount must be positive and sorted by denom: %s", amount)
+	}
+	return mintcoin.Credit{Addr: addr, Amount: coins}, nil
+}
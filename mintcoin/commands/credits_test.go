@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/basecoin-examples/mintcoin"
+	"github.com/tendermint/basecoin/types"
+)
+
+const (
+	addrHex1 = "D397BC62B435F3CF50570FBAB4340FE52C60858F"
+	addrHex2 = "0x1B1BE55F969F54064628A63B9559E7C21C925165"
+)
+
+func TestParseCredit(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		addr, amount string
+		valid        bool
+	}{
+		{addrHex1, "10BTC", true},
+		{addrHex2, "5BTC,10cosmo", true},
+		{" " + addrHex1 + " ", "10BTC", true},
+		{"1234", "10BTC", true}, // the length is only checked in credits files
+		{"D397BC62B435F3CF50570FBAB4340FE52C60858", "10BTC", false},
+		{"hello", "10BTC", false},
+		{addrHex1, "0BTC", false},
+		{addrHex1, "10cosmo,5BTC", false},
+		{addrHex1, "", false},
+	}
+
+	for _, tc := range cases {
+		credit, err := parseCredit(tc.addr, tc.amount)
+		if tc.valid {
+			assert.Nil(err, "%s %s: %v", tc.addr, tc.amount, err)
+			assert.True(credit.Amount.IsPositive(), tc.amount)
+		} else {
+			assert.NotNil(err, "%s %s", tc.addr, tc.amount)
+		}
+	}
+}
+
+func TestReadCreditsCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	rows, err := readCreditsCSV(strings.NewReader(
+		"# address, amount\n" + addrHex1 + ", 10BTC\n" + addrHex2 + ",\"5BTC,10cosmo\"\n"))
+	if assert.Nil(err) {
+		assert.Equal([]creditRow{
+			{addrHex1, "10BTC"},
+			{addrHex2, "5BTC,10cosmo"},
+		}, rows)
+	}
+
+	rows, err = readCreditsCSV(strings.NewReader(""))
+	assert.Nil(err)
+	assert.Empty(rows)
+
+	// every row needs an address and an amount
+	_, err = readCreditsCSV(strings.NewReader(addrHex1 + ",10BTC\n" + addrHex2 + "\n"))
+	assert.NotNil(err)
+	_, err = readCreditsCSV(strings.NewReader(addrHex1 + ",10BTC,5cosmo\n"))
+	assert.NotNil(err)
+	_, err = readCreditsCSV(strings.NewReader(addrHex1 + ",\"10BTC\n"))
+	assert.NotNil(err)
+}
+
+func TestReadCreditsFile(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "credits")
+	if !assert.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file, contents string
+		credits        int
+	}{
+		{"good.csv", addrHex1 + ",10BTC\n" + addrHex2 + ",\"5BTC,10cosmo\"\n", 2},
+		{"good.json", `[{"address": "` + addrHex1 + `", "amount": "10BTC"}]`, 1},
+		{"empty.csv", "", 0},
+		{"empty.json", "[]", 0},
+		{"comments.csv", "# nothing to mint\n", 0},
+		{"malformed.csv", addrHex1 + "\n", 0},
+		{"malformed.json", `{"address": "` + addrHex1 + `"}`, 0},
+		{"hex.csv", "hello,10BTC\n", 0},
+		{"short.csv", "1234,10BTC\n", 0},
+		{"amount.csv", addrHex1 + ",10BTC\n" + addrHex2 + ",0BTC\n", 0},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.file)
+		if !assert.Nil(ioutil.WriteFile(path, []byte(tc.contents), 0600)) {
+			continue
+		}
+		credits, err := readCreditsFile(path)
+		if tc.credits > 0 {
+			assert.Nil(err, "%s: %v", tc.file, err)
+			assert.Equal(tc.credits, len(credits), tc.file)
+		} else {
+			assert.NotNil(err, tc.file)
+		}
+	}
+
+	addr, _ := hex.DecodeString(addrHex1)
+	credits, err := readCreditsFile(filepath.Join(dir, "good.csv"))
+	if assert.Nil(err) {
+		assert.Equal(mintcoin.Credit{
+			Addr:   addr,
+			Amount: types.Coins{{Denom: "BTC", Amount: 10}},
+		}, credits[0])
+	}
+
+	_, err = readCreditsFile(filepath.Join(dir, "missing.csv"))
+	assert.NotNil(err)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/tendermint/basecoin-examples/mintcoin"
 	bcmd "github.com/tendermint/basecoin/cmd/commands"
@@ -23,6 +24,26 @@ var (
 		Name:  "mint",
 		Usage: "Amount of coins to mint in format <amt><coin>,<amt2><coin2>,...",
 	}
+	MintCreditsFileFlag = cli.StringFlag{
+		Name:  "credits-file",
+		Usage: "File with many <address>,<amount> rows to mint in one tx (csv, or json if it ends in .json)",
+	}
+	MintCliffFlag = cli.Uint64Flag{
+		Name:  "vest-cliff",
+		Usage: "Block height before which none of the minted coins are released (optional)",
+	}
+	MintVestEndFlag = cli.Uint64Flag{
+		Name:  "vest-end",
+		Usage: "Block height when all minted coins are released, if set the coins vest linearly until then",
+	}
+	IssuerFlag = cli.StringFlag{
+		Name:  "issuer",
+		Usage: "Address of the issuer to add or remove",
+	}
+	DenomsFlag = cli.StringFlag{
+		Name:  "denoms",
+		Usage: "Denominations the issuer may mint in format <coin>,<coin2>,..., required, " + mintcoin.Wildcard + " for any",
+	}
 )
 
 var (
@@ -34,12 +55,48 @@ var (
 		},
 		Flags: append(bcmd.TxFlags,
 			MintToFlag,
-			MintAmountFlag),
+			MintAmountFlag,
+			MintCreditsFileFlag,
+			MintCliffFlag,
+			MintVestEndFlag),
+	}
+
+	BurnTxCmd = cli.Command{
+		Name:  "burn",
+		Usage: "Craft a transaction to destroy the coins sent with --amount",
+		Action: func(c *cli.Context) error {
+			return cmdBurnTx(c)
+		},
+		Flags: bcmd.TxFlags,
+	}
+
+	AddIssuerTxCmd = cli.Command{
+		Name:  "add-issuer",
+		Usage: "Approve letting a new issuer mint some denominations",
+		Action: func(c *cli.Context) error {
+			return cmdAddIssuerTx(c)
+		},
+		Flags: append(bcmd.TxFlags,
+			IssuerFlag,
+			DenomsFlag),
+	}
+
+	RemoveIssuerTxCmd = cli.Command{
+		Name:  "remove-issuer",
+		Usage: "Approve taking away all rights of an issuer",
+		Action: func(c *cli.Context) error {
+			return cmdRemoveIssuerTx(c)
+		},
+		Flags: append(bcmd.TxFlags,
+			IssuerFlag),
 	}
 )
 
 func init() {
 	bcmd.RegisterTxSubcommand(MintTxCmd)
+	bcmd.RegisterTxSubcommand(BurnTxCmd)
+	bcmd.RegisterTxSubcommand(AddIssuerTxCmd)
+	bcmd.RegisterTxSubcommand(RemoveIssuerTxCmd)
 	bcmd.RegisterStartPlugin(MintName,
 		func() types.Plugin { return mintcoin.New(MintName) })
 }
@@ -47,28 +104,100 @@ func init() {
 func cmdMintTx(c *cli.Context) error {
 	toHex := c.String(MintToFlag.Name)
 	mintAmount := c.String(MintAmountFlag.Name)
+	creditsFile := c.String(MintCreditsFileFlag.Name)
+
+	// either one credit from the flags, or many from the file
+	var credits mintcoin.Credits
+	if creditsFile != "" {
+		if toHex != "" || mintAmount != "" {
+			return errors.New("Cannot use --credits-file together with --mintto or --mint")
+		}
+		var err error
+		credits, err = readCreditsFile(creditsFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		credit, err := parseCredit(toHex, mintAmount)
+		if err != nil {
+			return err
+		}
+		credits = mintcoin.Credits{credit}
+	}
+
+	vesting := mintcoin.Vesting{
+		Cliff: c.Uint64(MintCliffFlag.Name),
+		End:   c.Uint64(MintVestEndFlag.Name),
+	}
+	for i := range credits {
+		credits[i].Vesting = vesting
+	}
+
+	mintTx := mintcoin.MintTx{
+		Credits: credits,
+	}
+	if creditsFile != "" {
+		fmt.Printf("MintTx: %d credits from %s\n", len(credits), creditsFile)
+	} else {
+		fmt.Println("MintTx:", string(wire.JSONBytes(mintTx)))
+	}
+	data := mintcoin.TxBytes(mintTx)
+
+	return bcmd.AppTx(c, MintName, data)
+}
+
+func cmdBurnTx(c *cli.Context) error {
+	// the coins to burn are the --amount sent with the AppTx
+	data := mintcoin.TxBytes(mintcoin.BurnTx{})
+	return bcmd.AppTx(c, MintName, data)
+}
 
-	// convert destination address to bytes
-	to, err := hex.DecodeString(bcmd.StripHex(toHex))
+func cmdAddIssuerTx(c *cli.Context) error {
+	addr, err := hex.DecodeString(bcmd.StripHex(c.String(IssuerFlag.Name)))
 	if err != nil {
-		return errors.New("To address is invalid hex: " + err.Error())
+		return errors.New("Issuer address is invalid hex: " + err.Error())
 	}
 
-	amountCoins, err := bcmd.ParseCoins(mintAmount)
+	denoms, err := parseDenoms(c.String(DenomsFlag.Name))
 	if err != nil {
 		return err
 	}
 
-	mintTx := mintcoin.MintTx{
-		Credits: []mintcoin.Credit{
-			{
-				Addr:   to,
-				Amount: amountCoins,
-			},
-		},
+	tx := mintcoin.AddIssuerTx{
+		Addr:   addr,
+		Denoms: denoms,
 	}
-	fmt.Println("MintTx:", string(wire.JSONBytes(mintTx)))
-	data := wire.BinaryBytes(mintTx)
+	fmt.Println("AddIssuerTx:", string(wire.JSONBytes(tx)))
+	data := mintcoin.TxBytes(tx)
+	return bcmd.AppTx(c, MintName, data)
+}
 
+// parseDenoms splits a comma separated list of denominations,
+// which must name at least one and have no empty entries
+func parseDenoms(list string) ([]string, error) {
+	if len(strings.TrimSpace(list)) == 0 {
+		return nil, errors.New("Denoms are required, use " + mintcoin.Wildcard + " to grant all of them")
+	}
+	denoms := strings.Split(list, ",")
+	for i, denom := range denoms {
+		denoms[i] = strings.TrimSpace(denom)
+		if len(denoms[i]) == 0 {
+			return nil, fmt.Errorf("Empty denom in %q", list)
+		}
+	}
+	return denoms, nil
+}
+
+func cmdRemoveIssuerTx(c *cli.Context) error {
+	addr, err := hex.DecodeString(bcmd.StripHex(c.String(IssuerFlag.Name)))
+	if err != nil {
+		return errors.New("Issuer address is invalid hex: " + err.Error())
+	}
+
+	tx := mintcoin.RemoveIssuerTx{
+		Addr: addr,
+	}
+	fmt.Println("RemoveIssuerTx:", string(wire.JSONBytes(tx)))
+	data := mintcoin.TxBytes(tx)
 	return bcmd.AppTx(c, MintName, data)
 }
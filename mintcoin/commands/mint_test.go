@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDenoms(t *testing.T) {
+	assert := assert.New(t)
+
+	denoms, err := parseDenoms(" USD, EUR ")
+	assert.Nil(err)
+	assert.Equal([]string{"USD", "EUR"}, denoms)
+	denoms, err = parseDenoms("*")
+	assert.Nil(err)
+	assert.Equal([]string{"*"}, denoms)
+
+	for _, list := range []string{"", "  ", "USD,,EUR", "USD,", " ,EUR"} {
+		_, err = parseDenoms(list)
+		assert.NotNil(err, list)
+	}
+}
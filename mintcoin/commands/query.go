@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/tendermint/basecoin-examples/mintcoin"
+	bcmd "github.com/tendermint/basecoin/cmd/commands"
+	wire "github.com/tendermint/go-wire"
+	"github.com/urfave/cli"
+)
+
+var (
+	MintIssuerFlag = cli.StringFlag{
+		Name:  "issuer",
+		Usage: "Only show mints by this issuer",
+	}
+	MintRecipientFlag = cli.StringFlag{
+		Name:  "recipient",
+		Usage: "Only show mints to this recipient",
+	}
+	PageFlag = cli.IntFlag{
+		Name:  "page",
+		Value: 1,
+		Usage: "Which page of results to show, starting at 1",
+	}
+	PerPageFlag = cli.IntFlag{
+		Name:  "per-page",
+		Value: 20,
+		Usage: "How many results to show per page",
+	}
+)
+
+var (
+	IssuersCmd = cli.Command{
+		Name:  "issuers",
+		Usage: "List all issuers and what they may mint",
+		Action: func(c *cli.Context) error {
+			return cmdIssuers(c)
+		},
+		Flags: []cli.Flag{
+			bcmd.NodeFlag,
+		},
+	}
+
+	SupplyCmd = cli.Command{
+		Name:      "supply",
+		Usage:     "Show the total supply of one or all minted denominations",
+		ArgsUsage: "[denom]",
+		Action: func(c *cli.Context) error {
+			return cmdSupply(c)
+		},
+		Flags: []cli.Flag{
+			bcmd.NodeFlag,
+		},
+	}
+
+	MintsQueryCmd = cli.Command{
+		Name:  "mints",
+		Usage: "Page through the history of all mints",
+		Action: func(c *cli.Context) error {
+			return cmdMintsQuery(c)
+		},
+		Flags: []cli.Flag{
+			bcmd.NodeFlag,
+			MintIssuerFlag,
+			MintRecipientFlag,
+			PageFlag,
+			PerPageFlag,
+		},
+	}
+)
+
+func init() {
+	bcmd.QueryCmd.Subcommands = append(bcmd.QueryCmd.Subcommands, MintsQueryCmd)
+}
+
+// mintRecord is a MintRecord along with its position in the log
+type mintRecord struct {
+	Index  uint64              `json:"index"`
+	Record mintcoin.MintRecord `json:"record"`
+}
+
+func cmdIssuers(c *cli.Context) error {
+	tmAddr := c.String("node")
+	var addrs [][]byte
+	err := queryValue(tmAddr, mintcoin.IssuersKey(MintName), &addrs)
+	if err != nil {
+		return err
+	}
+
+	issuers := make(mintcoin.Issuers, len(addrs))
+	for i, addr := range addrs {
+		err = queryValue(tmAddr, mintcoin.IssuerKey(MintName, addr), &issuers[i])
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(string(wire.JSONBytes(issuers)))
+	return nil
+}
+
+func cmdSupply(c *cli.Context) error {
+	tmAddr := c.String("node")
+	var denoms []string
+	switch len(c.Args()) {
+	case 0:
+		err := queryValue(tmAddr, mintcoin.DenomsKey(MintName), &denoms)
+		if err != nil {
+			return err
+		}
+	case 1:
+		denoms = []string{c.Args()[0]}
+	default:
+		return errors.New("supply command takes at most one argument ([denom])")
+	}
+
+	supplies := make([]mintcoin.Supply, len(denoms))
+	for i, denom := range denoms {
+		supplies[i].Denom = denom
+		err := queryValue(tmAddr, mintcoin.SupplyKey(MintName, denom), &supplies[i])
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(string(wire.JSONBytes(supplies)))
+	return nil
+}
+
+func cmdMintsQuery(c *cli.Context) error {
+	issuer, err := hexFlag(c, MintIssuerFlag.Name)
+	if err != nil {
+		return err
+	}
+	recipient, err := hexFlag(c, MintRecipientFlag.Name)
+	if err != nil {
+		return err
+	}
+	page, perPage := c.Int(PageFlag.Name), c.Int(PerPageFlag.Name)
+	if page < 1 || perPage < 1 {
+		return errors.New("page and per-page must be positive")
+	}
+
+	// walk the index of the records of the issuer or recipient if either is
+	// given, so the query does not grow with the whole log
+	tmAddr := c.String("node")
+	countKey := mintcoin.RecordCountKey(MintName)
+	var indexKey func(i uint64) []byte
+	switch {
+	case len(issuer) > 0:
+		countKey = mintcoin.IssuerRecordCountKey(MintName, issuer)
+		indexKey = func(i uint64) []byte { return mintcoin.IssuerRecordKey(MintName, issuer, i) }
+	case len(recipient) > 0:
+		countKey = mintcoin.RecipientRecordCountKey(MintName, recipient)
+		indexKey = func(i uint64) []byte { return mintcoin.RecipientRecordKey(MintName, recipient, i) }
+	}
+	var count uint64
+	err = queryValue(tmAddr, countKey, &count)
+	if err != nil {
+		return err
+	}
+
+	// jump straight to the page, unless the recipient is still to be matched
+	start, skip := uint64(0), (page-1)*perPage
+	if len(issuer) == 0 || len(recipient) == 0 {
+		start, skip = uint64(skip), 0
+	}
+	results := []mintRecord{}
+	for i := start; i < count && len(results) < perPage; i++ {
+		n := i
+		if indexKey != nil {
+			err = queryValue(tmAddr, indexKey(i), &n)
+			if err != nil {
+				return err
+			}
+		}
+		var rec mintcoin.MintRecord
+		err = queryValue(tmAddr, mintcoin.RecordKey(MintName, n), &rec)
+		if err != nil {
+			return err
+		}
+		if len(recipient) > 0 && !bytes.Equal(recipient, rec.Recipient) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		results = append(results, mintRecord{n, rec})
+	}
+
+	fmt.Println(string(wire.JSONBytes(results)))
+	return nil
+}
+
+// hexFlag decodes an optional address flag
+func hexFlag(c *cli.Context, name string) ([]byte, error) {
+	addr, err := hex.DecodeString(bcmd.StripHex(c.String(name)))
+	if err != nil {
+		return nil, fmt.Errorf("%s address is invalid hex: %v", name, err)
+	}
+	return addr, nil
+}
+
+// queryValue reads the go-wire encoded value at key into ptr,
+// leaving it untouched if nothing is stored there
+func queryValue(tmAddr string, key []byte, ptr interface{}) error {
+	response, err := bcmd.Query(tmAddr, key)
+	if err != nil {
+		return err
+	}
+	if len(response.Value) == 0 {
+		return nil
+	}
+	err = wire.ReadBinaryBytes(response.Value, ptr)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", key, err)
+	}
+	return nil
+}
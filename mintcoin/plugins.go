@@ -3,6 +3,10 @@ package mintcoin
 import (
 	"encoding/hex"
 	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
 
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin/state"
@@ -11,17 +15,25 @@ import (
 )
 
 const (
-	AddIssuer    = "add"
-	RemoveIssuer = "remove"
+	AddIssuer       = "add"
+	RemoveIssuer    = "remove"
+	GrantDenom      = "grant"
+	RevokeDenom     = "revoke"
+	SupplyCap       = "cap"
+	RestrictBurn    = "restrict-burn"
+	IssuerQuorum    = "quorum"
+	IssuerAllowance = "allowance"
+	AllowancePeriod = "allowance-period"
 )
 
 // MintPlugin is a plugin, storing all state prefixed with it's unique name
 type MintPlugin struct {
-	name string
+	name   string
+	height uint64
 }
 
-func New(name string) MintPlugin {
-	return MintPlugin{name: name}
+func New(name string) *MintPlugin {
+	return &MintPlugin{name: name}
 }
 
 func (mp MintPlugin) Name() string {
@@ -30,100 +42,422 @@ func (mp MintPlugin) Name() string {
 
 // Set initial minters
 func (mp MintPlugin) SetOption(store types.KVStore, key string, value string) (log string) {
-	// value is always a hex-encoded address
-	addr, err := hex.DecodeString(value)
+	err := mp.migrate(store)
 	if err != nil {
-		return fmt.Sprintf("Invalid address: %s: %v", addr, err)
+		return err.Error()
 	}
 
 	switch key {
-	case AddIssuer:
-		s := mp.loadState(store)
-		s.AddIssuer(addr)
-		mp.saveState(store, s)
-		mp.saveState(store, s)
-		return fmt.Sprintf("Added: %s", addr)
-	case RemoveIssuer:
-		s := mp.loadState(store)
-		s.RemoveIssuer(addr)
-		mp.saveState(store, s)
-		return fmt.Sprintf("Removed: %s", addr)
+	case AddIssuer, RemoveIssuer:
+		// value is always a hex-encoded address
+		addr, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Sprintf("Invalid address: %s: %v", value, err)
+		}
+		if key == AddIssuer {
+			// an issuer keeps the denoms it was granted, the
+			// Wildcard must be granted explicitly to widen them
+			issuer := mp.loadIssuer(store, addr)
+			if issuer.IsIssuer() {
+				return fmt.Sprintf("Already an issuer: %X", addr)
+			}
+			issuer.Grant(Wildcard)
+			mp.saveIssuer(store, issuer)
+			return fmt.Sprintf("Added: %X", addr)
+		}
+		mp.removeIssuer(store, addr)
+		return fmt.Sprintf("Removed: %X", addr)
+	case GrantDenom, RevokeDenom:
+		// value is <hex address>:<denom>
+		addr, denom, err := parseAddrDenom(value)
+		if err != nil {
+			return err.Error()
+		}
+		issuer := mp.loadIssuer(store, addr)
+		if key == GrantDenom {
+			issuer.Grant(denom)
+			mp.saveIssuer(store, issuer)
+			return fmt.Sprintf("Granted %s to: %X", denom, addr)
+		}
+		// once all permissions are revoked, addr is no longer an issuer
+		issuer.Revoke(denom)
+		mp.saveIssuer(store, issuer)
+		return fmt.Sprintf("Revoked %s from: %X", denom, addr)
+	case SupplyCap:
+		// value is <amount><denom>, a zero amount removes the cap
+		coin, err := parseCoin(value)
+		if err != nil {
+			return err.Error()
+		}
+		supply := mp.loadSupply(store, coin.Denom)
+		supply.Cap = coin.Amount
+		mp.saveSupply(store, supply)
+		return fmt.Sprintf("Cap for %s: %d", coin.Denom, coin.Amount)
+	case RestrictBurn:
+		// value is true or false
+		restrict, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Sprintf("Invalid value: %s: %v", value, err)
+		}
+		config := mp.loadConfig(store)
+		config.RestrictBurn = restrict
+		mp.saveConfig(store, config)
+		return fmt.Sprintf("Restrict burn: %t", restrict)
+	case IssuerQuorum:
+		// value is the number of approvals, 0 for a majority of issuers
+		quorum, err := strconv.Atoi(value)
+		if err != nil || quorum < 0 {
+			return fmt.Sprintf("Invalid quorum: %s", value)
+		}
+		config := mp.loadConfig(store)
+		config.Quorum = quorum
+		mp.saveConfig(store, config)
+		return fmt.Sprintf("Quorum: %d", quorum)
+	case IssuerAllowance:
+		// value is <hex address>:<amount><denom>, a zero amount removes it
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Sprintf("Invalid value, expected <address>:<amount><denom>: %s", value)
+		}
+		addr, err := hex.DecodeString(parts[0])
+		if err != nil {
+			return fmt.Sprintf("Invalid address: %s: %v", parts[0], err)
+		}
+		coin, err := parseCoin(parts[1])
+		if err != nil {
+			return err.Error()
+		}
+		issuer := mp.loadIssuer(store, addr)
+		if !issuer.IsIssuer() {
+			return fmt.Sprintf("Not an issuer: %X", addr)
+		}
+		issuer.SetAllowance(coin.Denom, coin.Amount)
+		mp.saveIssuer(store, issuer)
+		return fmt.Sprintf("Allowance of %X: %v", addr, coin)
+	case AllowancePeriod:
+		// value is the number of blocks, 0 to never replenish
+		period, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("Invalid period: %s: %v", value, err)
+		}
+		config := mp.loadConfig(store)
+		config.AllowancePeriod = period
+		mp.saveConfig(store, config)
+		return fmt.Sprintf("Allowance period: %d", period)
 	default:
 		return fmt.Sprintf("Unknown key: %s", key)
 	}
 }
 
-// This allows
+// parse out which tx we use and then run it
 func (mp MintPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
-	// parse transaction
-	var tx MintTx
-	err := wire.ReadBinaryBytes(txBytes, &tx)
+	tx, err := ParseTx(txBytes)
 	if err != nil {
 		return abci.ErrEncodingError
 	}
+	err = mp.migrate(store)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog(err.Error())
+	}
+
+	switch t := tx.(type) {
+	case MintTx:
+		return mp.runMintTx(store, ctx, t)
+	case BurnTx:
+		return mp.runBurnTx(store, ctx, t)
+	case AddIssuerTx:
+		if len(t.Denoms) == 0 {
+			return abci.ErrBaseInvalidInput.AppendLog("No denoms to grant")
+		}
+		for _, denom := range t.Denoms {
+			if len(denom) == 0 {
+				return abci.ErrBaseInvalidInput.AppendLog("Cannot grant an empty denom")
+			}
+		}
+		return mp.runProposal(store, ctx, t)
+	case RemoveIssuerTx:
+		return mp.runProposal(store, ctx, t)
+	default:
+		return abci.ErrUnknownRequest
+	}
+}
 
+// runMintTx lets an issuer credit new coins to any accounts
+func (mp MintPlugin) runMintTx(store types.KVStore, ctx types.CallContext, tx MintTx) abci.Result {
 	// make sure it was signed by a Issuer
-	s := mp.loadState(store)
-	if !s.IsIssuer(ctx.CallerAddress) {
+	issuer := mp.loadIssuer(store, ctx.CallerAddress)
+	if !issuer.IsIssuer() {
 		return abci.ErrUnauthorized
 	}
 
-	// now, send all this money!
+	// and is allowed to mint every denomination in the tx
+	var total types.Coins
 	for _, credit := range tx.Credits {
-		// load or create account
-		acct := state.GetAccount(store, credit.Addr)
-		if acct == nil {
-			acct = &types.Account{
-				PubKey:   nil,
-				Sequence: 0,
+		if !credit.Amount.IsValid() || !credit.Amount.IsPositive() {
+			return abci.ErrBaseInvalidInput.AppendLog("Invalid credit amount")
+		}
+		if !credit.Vesting.IsValid(mp.height) {
+			return abci.ErrBaseInvalidInput.AppendLog("Invalid vesting schedule")
+		}
+		for _, coin := range credit.Amount {
+			if !issuer.CanIssue(coin.Denom) {
+				return abci.ErrUnauthorized.AppendLog(
+					fmt.Sprintf("Not allowed to mint %s", coin.Denom))
+			}
+			if coin.Amount > math.MaxInt64-amountOf(total, coin.Denom) {
+				return abci.ErrBaseInvalidInput.AppendLog(
+					fmt.Sprintf("Minting would overflow the supply of %s", coin.Denom))
 			}
 		}
+		total = total.Plus(credit.Amount)
+	}
 
-		// add the money
-		acct.Balance = acct.Balance.Plus(credit.Amount)
+	// no denomination may go over its cap
+	supplies := make([]Supply, len(total))
+	for i, coin := range total {
+		supplies[i] = mp.loadSupply(store, coin.Denom)
+		if supplies[i].Overflows(coin.Amount) {
+			return abci.ErrBaseInvalidInput.AppendLog(
+				fmt.Sprintf("Minting would overflow the supply of %s", coin.Denom))
+		}
+		if !supplies[i].CanMint(coin.Amount) {
+			return abci.ErrUnauthorized.AppendLog(
+				fmt.Sprintf("Minting would exceed the cap for %s", coin.Denom))
+		}
+		supplies[i].Total += coin.Amount
+	}
 
-		// and save the new balance
-		state.SetAccount(store, credit.Addr, acct)
+	// nor over the issuer's allowance
+	period := mp.loadConfig(store).Period(mp.height)
+	for _, coin := range total {
+		if !issuer.Spend(coin.Denom, coin.Amount, period) {
+			return abci.ErrUnauthorized.AppendLog(
+				fmt.Sprintf("Minting would exceed the allowance for %s", coin.Denom))
+		}
 	}
 
+	// now, send all this money, or lock it up until it vests
+	for _, credit := range tx.Credits {
+		if credit.Vesting.IsZero() {
+			pay(store, credit.Addr, credit.Amount)
+		} else {
+			mp.addVesting(store, VestingSchedule{
+				Recipient: credit.Addr,
+				Start:     mp.height,
+				Vesting:   credit.Vesting,
+				Total:     credit.Amount,
+			})
+		}
+
+		// keep a trace for reconciliation
+		mp.appendRecord(store, MintRecord{
+			Height:    mp.height,
+			Issuer:    ctx.CallerAddress,
+			Recipient: credit.Addr,
+			Amount:    credit.Amount,
+		})
+	}
+
+	// record what was created
+	for _, supply := range supplies {
+		mp.saveSupply(store, supply)
+	}
+	mp.saveIssuer(store, issuer)
+
 	return abci.Result{}
 }
 
-// placeholders empty to fulfill interface
-func (mp MintPlugin) InitChain(store types.KVStore, vals []*abci.Validator)            {}
-func (mp MintPlugin) BeginBlock(store types.KVStore, hash []byte, header *abci.Header) {}
-func (mp MintPlugin) EndBlock(store types.KVStore, height uint64) abci.ResponseEndBlock {
+// runBurnTx destroys the coins sent with the tx, by simply keeping them
+func (mp MintPlugin) runBurnTx(store types.KVStore, ctx types.CallContext, tx BurnTx) abci.Result {
+	if !ctx.Coins.IsPositive() {
+		return abci.ErrBaseInvalidInput.AppendLog("No coins to burn")
+	}
+
+	config := mp.loadConfig(store)
+	issuer := mp.loadIssuer(store, ctx.CallerAddress)
+	supplies := make([]Supply, len(ctx.Coins))
+	for i, coin := range ctx.Coins {
+		if config.RestrictBurn && !issuer.CanIssue(coin.Denom) {
+			return abci.ErrUnauthorized.AppendLog(
+				fmt.Sprintf("Not allowed to burn %s", coin.Denom))
+		}
+		// we can only destroy what was minted
+		supplies[i] = mp.loadSupply(store, coin.Denom)
+		if supplies[i].Total < coin.Amount {
+			return abci.ErrBaseInvalidInput.AppendLog(
+				fmt.Sprintf("Cannot burn more %s than was minted", coin.Denom))
+		}
+		supplies[i].Total -= coin.Amount
+	}
+
+	for _, supply := range supplies {
+		mp.saveSupply(store, supply)
+	}
+	return abci.OK.AppendLog(fmt.Sprintf("Burned %v", ctx.Coins))
+}
+
+// runProposal records the caller's approval of an issuer change,
+// and executes it once a quorum of issuers approved
+func (mp MintPlugin) runProposal(store types.KVStore, ctx types.CallContext, tx Tx) abci.Result {
+	if !mp.isIssuer(store, ctx.CallerAddress) {
+		return abci.ErrUnauthorized
+	}
+
+	id := ProposalID(tx)
+	p := mp.loadProposal(store, id)
+	if p.Tx == nil {
+		p.Tx = tx
+	}
+	if p.HasApproved(ctx.CallerAddress) {
+		return abci.ErrBaseInvalidInput.AppendLog("Proposal already approved")
+	}
+	p.Approvals = append(p.Approvals, ctx.CallerAddress)
+
+	config := mp.loadConfig(store)
+	isIssuer := func(addr []byte) bool { return mp.isIssuer(store, addr) }
+	issuers := len(mp.loadIssuerAddrs(store))
+	if p.Count(isIssuer) < config.Required(issuers) {
+		mp.saveProposal(store, id, p)
+		return abci.NewResultOK(id, "Proposal approved")
+	}
+
+	// we have enough votes, so let's change the issuers
+	switch t := tx.(type) {
+	case AddIssuerTx:
+		issuer := mp.loadIssuer(store, t.Addr)
+		for _, denom := range t.Denoms {
+			issuer.Grant(denom)
+		}
+		mp.saveIssuer(store, issuer)
+	case RemoveIssuerTx:
+		mp.removeIssuer(store, t.Addr)
+	}
+	store.Set(ProposalKey(mp.name, id), nil)
+	return abci.NewResultOK(id, "Proposal executed")
+}
+
+// placeholder empty to fulfill interface
+func (mp *MintPlugin) InitChain(store types.KVStore, vals []*abci.Validator) {}
+
+// track the height for the allowance periods, and release vested coins.
+// The legacy state is migrated here too, so the issuers can be queried
+// from the first block on. A state which fails to migrate is left for
+// SetOption and RunTx to report.
+func (mp *MintPlugin) BeginBlock(store types.KVStore, hash []byte, header *abci.Header) {
+	mp.height = header.Height
+	mp.migrate(store)
+	mp.releaseVested(store)
+}
+
+func (mp *MintPlugin) EndBlock(store types.KVStore, height uint64) abci.ResponseEndBlock {
 	return abci.ResponseEndBlock{}
 }
 
-func (mp MintPlugin) assertPlugin() types.Plugin {
+func (mp *MintPlugin) assertPlugin() types.Plugin {
 	return mp
 }
 
 /*** implementation ***/
 
-func (mp MintPlugin) stateKey() []byte {
-	key := fmt.Sprintf("*%s*", mp.name)
-	return []byte(key)
+// pay adds coins to the account at addr, creating it if needed
+func pay(store types.KVStore, addr []byte, coins types.Coins) {
+	acct := state.GetAccount(store, addr)
+	if acct == nil {
+		acct = &types.Account{
+			PubKey:   nil,
+			Sequence: 0,
+		}
+	}
+	acct.Balance = acct.Balance.Plus(coins)
+	state.SetAccount(store, addr, acct)
+}
+
+// amountOf returns how many coins of denom are in coins
+func amountOf(coins types.Coins, denom string) int64 {
+	for _, coin := range coins {
+		if coin.Denom == denom {
+			return coin.Amount
+		}
+	}
+	return 0
 }
 
-func (mp MintPlugin) loadState(store types.KVStore) *MintState {
-	var s MintState
-	data := store.Get(mp.stateKey())
-	// here return an uninitialized state
-	if len(data) == 0 {
-		return &s
+// releaseVested pays out everything that vested by the current height,
+// and forgets the schedules that are done. Schedules are only looked at
+// once they reached their cliff.
+func (mp MintPlugin) releaseVested(store types.KVStore) {
+	ids, changed := mp.loadVestingIDs(store), false
+
+	// take in the schedules reaching their cliff since the last block
+	var last uint64
+	if data := store.Get(mp.vestingHeightKey()); len(data) > 0 {
+		err := wire.ReadBinaryBytes(data, &last)
+		if err != nil {
+			panic(err)
+		}
 	}
+	if last == 0 || last >= mp.height {
+		last = mp.height - 1
+	}
+	for h := last + 1; h <= mp.height; h++ {
+		if cliff := loadIDs(store, VestingCliffKey(mp.name, h)); len(cliff) > 0 {
+			ids, changed = append(ids, cliff...), true
+			store.Set(VestingCliffKey(mp.name, h), nil)
+		}
+	}
+	store.Set(mp.vestingHeightKey(), wire.BinaryBytes(mp.height))
 
-	err := wire.ReadBinaryBytes(data, &s)
-	// this should never happen, but we should also never panic....
+	active := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		v, err := mp.loadVesting(store, id)
+		if err != nil {
+			panic(err)
+		}
+		vested := v.Vested(mp.height)
+		due := vested.Minus(v.Released)
+		if !due.IsPositive() {
+			active = append(active, id)
+			continue
+		}
+		pay(store, v.Recipient, due)
+		v.Released = vested
+		if v.Released.IsEqual(v.Total) {
+			store.Set(VestingKey(mp.name, id), nil)
+			changed = true
+			continue
+		}
+		store.Set(VestingKey(mp.name, id), wire.BinaryBytes(v))
+		active = append(active, id)
+	}
+	if changed {
+		store.Set(VestingIDsKey(mp.name), wire.BinaryBytes(active))
+	}
+}
+
+var coinRegexp = regexp.MustCompile(`^([0-9]+)([a-zA-Z]+)$`)
+
+// parseCoin reads an option value of the form <amount><denom>
+func parseCoin(value string) (types.Coin, error) {
+	matches := coinRegexp.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return types.Coin{}, fmt.Errorf("Invalid coin, expected <amount><denom>: %s", value)
+	}
+	amount, err := strconv.ParseInt(matches[1], 10, 64)
 	if err != nil {
-		panic(err)
+		return types.Coin{}, fmt.Errorf("Invalid amount: %s: %v", matches[1], err)
 	}
-	return &s
+	return types.Coin{Denom: matches[2], Amount: amount}, nil
 }
 
-func (mp MintPlugin) saveState(store types.KVStore, state *MintState) {
-	value := wire.BinaryBytes(*state)
-	store.Set(mp.stateKey(), value)
+// parseAddrDenom splits an option value of the form <hex address>:<denom>
+func parseAddrDenom(value string) ([]byte, string, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", fmt.Errorf("Invalid value, expected <address>:<denom>: %s", value)
+	}
+	addr, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid address: %s: %v", parts[0], err)
+	}
+	return addr, parts[1], nil
 }
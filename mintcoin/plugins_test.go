@@ -2,9 +2,11 @@ package mintcoin
 
 import (
 	"encoding/hex"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin/state"
 	"github.com/tendermint/basecoin/types"
 	wire "github.com/tendermint/go-wire"
@@ -16,16 +18,64 @@ func TestSaveLoad(t *testing.T) {
 	plugin := New("cash")
 	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
 
-	s := plugin.loadState(store)
-	assert.NotNil(s)
-	assert.False(s.IsIssuer(addr1))
-	s.AddIssuer(addr1)
-	plugin.saveState(store, s)
+	i := plugin.loadIssuer(store, addr1)
+	assert.False(i.IsIssuer())
+	assert.False(plugin.isIssuer(store, addr1))
+	i.Grant("USD")
+	plugin.saveIssuer(store, i)
 
-	s2 := plugin.loadState(store)
-	assert.NotNil(s2)
-	assert.True(s2.IsIssuer(addr1))
-	assert.False(s2.IsIssuer(addr2))
+	i2 := plugin.loadIssuer(store, addr1)
+	assert.True(i2.CanIssue("USD"))
+	assert.True(plugin.isIssuer(store, addr1))
+	assert.False(plugin.isIssuer(store, addr2))
+	assert.Equal([][]byte{addr1}, plugin.loadIssuerAddrs(store))
+
+	// saving an issuer without permissions removes it
+	i2.Revoke("USD")
+	plugin.saveIssuer(store, i2)
+	assert.False(plugin.isIssuer(store, addr1))
+	assert.Empty(plugin.loadIssuerAddrs(store))
+}
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+
+	// the old format had the addresses of all issuers in one blob, encoded
+	// as the baseline MintState{Issuers []Issuer} with Issuer []byte
+	type baselineIssuer []byte
+	legacy := struct {
+		Issuers []baselineIssuer
+	}{[]baselineIssuer{addr1, addr2}}
+	store.Set(StateKey("cash"), wire.BinaryBytes(legacy))
+
+	// they keep minting anything they like
+	plugin.SetOption(store, GrantDenom, hex.EncodeToString(addr2)+":EUR")
+	assert.Empty(store.Get(StateKey("cash")))
+	assert.Equal([][]byte{addr1, addr2}, plugin.loadIssuerAddrs(store))
+	i1 := plugin.loadIssuer(store, addr1)
+	assert.Equal([]string{Wildcard}, i1.Denoms)
+	assert.Empty(i1.Allowances)
+	i2 := plugin.loadIssuer(store, addr2)
+	assert.Equal([]string{Wildcard, "EUR"}, i2.Denoms)
+
+	// and are migrated by the first block, before any tx
+	other := New("other")
+	store.Set(StateKey("other"), wire.BinaryBytes(legacy))
+	other.BeginBlock(store, nil, &abci.Header{Height: 1})
+	assert.Empty(store.Get(StateKey("other")))
+	assert.Equal([][]byte{addr1, addr2}, other.loadIssuerAddrs(store))
+
+	// a corrupt blob is an error, not a panic
+	store.Set(StateKey("cash"), []byte{0xFF})
+	log := plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr1))
+	assert.Contains(log, "Error decoding legacy state")
+	assert.NotPanics(func() { plugin.BeginBlock(store, nil, &abci.Header{Height: 2}) })
+	tx := MintTx{Credits{{Addr: addr1, Amount: types.Coins{{Denom: "USD", Amount: 1}}}}}
+	res := plugin.RunTx(store, types.CallContext{CallerAddress: addr1}, tx.Serialize())
+	assert.True(res.IsErr())
 }
 
 func TestSetOptions(t *testing.T) {
@@ -39,20 +89,17 @@ func TestSetOptions(t *testing.T) {
 	assert.Equal("cash", plugin.Name())
 
 	plugin.SetOption(store, AddIssuer, hex1)
-	st := plugin.loadState(store)
-	assert.True(st.IsIssuer(addr1))
-	assert.False(st.IsIssuer(addr2))
+	assert.True(plugin.isIssuer(store, addr1))
+	assert.False(plugin.isIssuer(store, addr2))
 
 	plugin.SetOption(store, RemoveIssuer, hex2)
-	st = plugin.loadState(store)
-	assert.True(st.IsIssuer(addr1))
-	assert.False(st.IsIssuer(addr2))
+	assert.True(plugin.isIssuer(store, addr1))
+	assert.False(plugin.isIssuer(store, addr2))
 
 	plugin.SetOption(store, AddIssuer, hex2)
 	plugin.SetOption(store, RemoveIssuer, hex1)
-	st = plugin.loadState(store)
-	assert.False(st.IsIssuer(addr1))
-	assert.True(st.IsIssuer(addr2))
+	assert.False(plugin.isIssuer(store, addr1))
+	assert.True(plugin.isIssuer(store, addr2))
 }
 
 func TestTransactions(t *testing.T) {
@@ -80,7 +127,7 @@ func TestTransactions(t *testing.T) {
 			},
 		},
 	}
-	txBytes := wire.BinaryBytes(tx)
+	txBytes := TxBytes(tx)
 	ctx := types.CallContext{CallerAddress: addr1}
 	res := plugin.RunTx(store, ctx, txBytes)
 
@@ -109,3 +156,401 @@ func TestTransactions(t *testing.T) {
 	assert.Equal("USD", usd.Denom)
 	assert.Equal(int64(75), usd.Amount)
 }
+
+func TestDenomPermissions(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	hex1 := hex.EncodeToString(addr1)
+	ctx := types.CallContext{CallerAddress: addr1}
+
+	mint := func(denom string) []byte {
+		tx := MintTx{
+			Credits{{
+				Addr:   addr2,
+				Amount: types.Coins{{Denom: denom, Amount: 10}},
+			}},
+		}
+		return TxBytes(tx)
+	}
+
+	// only USD may be minted after the grant
+	plugin.SetOption(store, GrantDenom, hex1+":USD")
+	res := plugin.RunTx(store, ctx, mint("USD"))
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.RunTx(store, ctx, mint("EUR"))
+	assert.True(res.IsErr())
+
+	// adding an issuer again keeps it to its denoms
+	log := plugin.SetOption(store, AddIssuer, hex1)
+	assert.Contains(log, "Already an issuer")
+	res = plugin.RunTx(store, ctx, mint("EUR"))
+	assert.True(res.IsErr())
+
+	acct := state.GetAccount(store, addr2)
+	if assert.NotNil(acct) {
+		assert.Equal(types.Coins{{Denom: "USD", Amount: 10}}, acct.Balance)
+	}
+
+	// revoking the last denom takes away all rights
+	plugin.SetOption(store, RevokeDenom, hex1+":USD")
+	assert.False(plugin.isIssuer(store, addr1))
+	res = plugin.RunTx(store, ctx, mint("USD"))
+	assert.True(res.IsErr())
+
+	// malformed values are rejected
+	log = plugin.SetOption(store, GrantDenom, hex1)
+	assert.Contains(log, "Invalid")
+	assert.False(plugin.isIssuer(store, addr1))
+}
+
+func TestSupply(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr1))
+	plugin.SetOption(store, SupplyCap, "100USD")
+	ctx := types.CallContext{CallerAddress: addr1}
+
+	mint := func(amount types.Coins) []byte {
+		tx := MintTx{
+			Credits{
+				{Addr: addr1, Amount: amount},
+				{Addr: addr2, Amount: amount},
+			},
+		}
+		return TxBytes(tx)
+	}
+
+	// both credits count towards the supply
+	res := plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "EUR", Amount: 30}, {Denom: "USD", Amount: 40}}))
+	assert.True(res.IsOK(), res.Log)
+	usd := plugin.loadSupply(store, "USD")
+	assert.Equal(int64(80), usd.Total)
+	assert.Equal(int64(100), usd.Cap)
+	eur := plugin.loadSupply(store, "EUR")
+	assert.Equal(int64(60), eur.Total)
+	assert.Equal(int64(0), eur.Cap)
+	assert.Equal([]string{"USD", "EUR"}, plugin.loadDenoms(store))
+
+	// going over the cap fails and changes nothing
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "USD", Amount: 11}}))
+	assert.True(res.IsErr())
+	assert.Equal(int64(80), plugin.loadSupply(store, "USD").Total)
+
+	// negative amounts can't sneak past
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "USD", Amount: -5}}))
+	assert.True(res.IsErr())
+
+	// up to the cap is fine, and the cap can be lifted
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "USD", Amount: 10}}))
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(100), plugin.loadSupply(store, "USD").Total)
+	plugin.SetOption(store, SupplyCap, "0USD")
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "USD", Amount: 10}}))
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(120), plugin.loadSupply(store, "USD").Total)
+
+	// without a cap the supply still can't overflow, neither across
+	// the credits of one tx nor on top of what was minted before
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "EUR", Amount: math.MaxInt64/2 + 1}}))
+	assert.True(res.IsErr())
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "EUR", Amount: math.MaxInt64/2 - 40}}))
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(math.MaxInt64-21), plugin.loadSupply(store, "EUR").Total)
+	res = plugin.RunTx(store, ctx, mint(types.Coins{{Denom: "EUR", Amount: 11}}))
+	assert.True(res.IsErr())
+	assert.Equal(int64(math.MaxInt64-21), plugin.loadSupply(store, "EUR").Total)
+
+	log := plugin.SetOption(store, SupplyCap, "lots")
+	assert.Contains(log, "Invalid")
+}
+
+func TestBurn(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	plugin.SetOption(store, GrantDenom, hex.EncodeToString(addr1)+":USD")
+	tx := MintTx{
+		Credits{{
+			Addr:   addr2,
+			Amount: types.Coins{{Denom: "USD", Amount: 50}},
+		}},
+	}
+	res := plugin.RunTx(store, types.CallContext{CallerAddress: addr1}, tx.Serialize())
+	assert.True(res.IsOK(), res.Log)
+
+	burn := BurnTx{}.Serialize()
+	burnCtx := func(amount int64) types.CallContext {
+		return types.CallContext{
+			CallerAddress: addr2,
+			Coins:         types.Coins{{Denom: "USD", Amount: amount}},
+		}
+	}
+
+	// anyone may burn by default
+	res = plugin.RunTx(store, burnCtx(20), burn)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(30), plugin.loadSupply(store, "USD").Total)
+
+	// but not more than exists, nor nothing at all
+	res = plugin.RunTx(store, burnCtx(31), burn)
+	assert.True(res.IsErr())
+	res = plugin.RunTx(store, types.CallContext{CallerAddress: addr2}, burn)
+	assert.True(res.IsErr())
+
+	// once restricted, only the issuer may burn
+	plugin.SetOption(store, RestrictBurn, "true")
+	res = plugin.RunTx(store, burnCtx(10), burn)
+	assert.True(res.IsErr())
+	ctx := burnCtx(10)
+	ctx.CallerAddress = addr1
+	res = plugin.RunTx(store, ctx, burn)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(20), plugin.loadSupply(store, "USD").Total)
+}
+
+func TestIssuerProposals(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2, addr3 := []byte("bigmoney"), []byte("litlefish"), []byte("newcomer")
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr1))
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr2))
+	ctx1 := types.CallContext{CallerAddress: addr1}
+	ctx2 := types.CallContext{CallerAddress: addr2}
+	ctx3 := types.CallContext{CallerAddress: addr3}
+
+	// a majority of two issuers is both of them
+	add := AddIssuerTx{Addr: addr3, Denoms: []string{"USD"}}
+	res := plugin.RunTx(store, ctx3, add.Serialize())
+	assert.True(res.IsErr())
+	res = plugin.RunTx(store, ctx1, add.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(ProposalID(add), res.Data)
+	assert.False(plugin.isIssuer(store, addr3))
+
+	// approving twice doesn't count
+	res = plugin.RunTx(store, ctx1, add.Serialize())
+	assert.True(res.IsErr())
+	assert.False(plugin.isIssuer(store, addr3))
+
+	res = plugin.RunTx(store, ctx2, add.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	i3 := plugin.loadIssuer(store, addr3)
+	assert.True(i3.CanIssue("USD"))
+	assert.False(i3.CanIssue("EUR"))
+	assert.Nil(plugin.loadProposal(store, ProposalID(add)).Tx)
+
+	// with a quorum of one, a single issuer decides
+	plugin.SetOption(store, IssuerQuorum, "1")
+	remove := RemoveIssuerTx{Addr: addr1}
+	res = plugin.RunTx(store, ctx3, remove.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	assert.False(plugin.isIssuer(store, addr1))
+
+	// approvals of removed issuers no longer count
+	plugin.SetOption(store, IssuerQuorum, "2")
+	add = AddIssuerTx{Addr: addr1, Denoms: []string{Wildcard}}
+	res = plugin.RunTx(store, ctx2, add.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	plugin.SetOption(store, RemoveIssuer, hex.EncodeToString(addr2))
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString([]byte("outsider")))
+	res = plugin.RunTx(store, ctx3, add.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	assert.False(plugin.isIssuer(store, addr1))
+	p := plugin.loadProposal(store, ProposalID(add))
+	assert.Equal(2, len(p.Approvals))
+	isIssuer := func(addr []byte) bool { return plugin.isIssuer(store, addr) }
+	assert.Equal(1, p.Count(isIssuer))
+
+	// must grant something
+	res = plugin.RunTx(store, ctx3, AddIssuerTx{Addr: addr2}.Serialize())
+	assert.True(res.IsErr())
+	res = plugin.RunTx(store, ctx3, AddIssuerTx{Addr: addr2, Denoms: []string{"USD", ""}}.Serialize())
+	assert.True(res.IsErr())
+}
+
+func TestAllowancePeriods(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	hex1 := hex.EncodeToString(addr1)
+	plugin.SetOption(store, AddIssuer, hex1)
+	plugin.SetOption(store, IssuerAllowance, hex1+":100USD")
+	plugin.SetOption(store, AllowancePeriod, "10")
+	ctx := types.CallContext{CallerAddress: addr1}
+
+	mint := func(amount int64) []byte {
+		tx := MintTx{
+			Credits{{
+				Addr:   addr2,
+				Amount: types.Coins{{Denom: "USD", Amount: amount}},
+			}},
+		}
+		return tx.Serialize()
+	}
+
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 12})
+	res := plugin.RunTx(store, ctx, mint(70))
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.RunTx(store, ctx, mint(31))
+	assert.True(res.IsErr())
+
+	// still the same period
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 19})
+	res = plugin.RunTx(store, ctx, mint(31))
+	assert.True(res.IsErr())
+
+	// now it replenished
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 20})
+	res = plugin.RunTx(store, ctx, mint(100))
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(int64(170), plugin.loadSupply(store, "USD").Total)
+
+	log := plugin.SetOption(store, IssuerAllowance, hex.EncodeToString(addr2)+":100USD")
+	assert.Contains(log, "Not an issuer")
+}
+
+func TestMintRecords(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr1))
+	ctx := types.CallContext{CallerAddress: addr1}
+	usd := types.Coins{{Denom: "USD", Amount: 7}}
+	eur := types.Coins{{Denom: "EUR", Amount: 3}}
+
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 5})
+	tx := MintTx{Credits{{Addr: addr1, Amount: usd}, {Addr: addr2, Amount: eur}}}
+	res := plugin.RunTx(store, ctx, tx.Serialize())
+	assert.True(res.IsOK(), res.Log)
+
+	// failed mints leave no trace
+	res = plugin.RunTx(store, types.CallContext{CallerAddress: addr2}, tx.Serialize())
+	assert.True(res.IsErr())
+
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 9})
+	tx = MintTx{Credits{{Addr: addr2, Amount: usd}}}
+	res = plugin.RunTx(store, ctx, tx.Serialize())
+	assert.True(res.IsOK(), res.Log)
+
+	assert.Equal(uint64(3), plugin.loadRecordCount(store))
+	expected := []MintRecord{
+		{Height: 5, Issuer: addr1, Recipient: addr1, Amount: usd},
+		{Height: 5, Issuer: addr1, Recipient: addr2, Amount: eur},
+		{Height: 9, Issuer: addr1, Recipient: addr2, Amount: usd},
+	}
+	for i, exp := range expected {
+		rec, err := plugin.loadRecord(store, uint64(i))
+		if assert.Nil(err) {
+			assert.Equal(exp, rec)
+		}
+	}
+	_, err := plugin.loadRecord(store, 3)
+	assert.NotNil(err)
+
+	// the records are indexed by issuer and recipient
+	assert.Equal(uint64(3), loadCount(store, IssuerRecordCountKey("cash", addr1)))
+	assert.Equal(uint64(0), loadCount(store, IssuerRecordCountKey("cash", addr2)))
+	assert.Equal(uint64(1), loadCount(store, RecipientRecordCountKey("cash", addr1)))
+	assert.Equal(uint64(2), loadCount(store, RecipientRecordCountKey("cash", addr2)))
+	assert.Equal(uint64(2), loadCount(store, IssuerRecordKey("cash", addr1, 2)))
+	assert.Equal(uint64(0), loadCount(store, RecipientRecordKey("cash", addr1, 0)))
+	assert.Equal(uint64(1), loadCount(store, RecipientRecordKey("cash", addr2, 0)))
+	assert.Equal(uint64(2), loadCount(store, RecipientRecordKey("cash", addr2, 1)))
+}
+
+// countingStore counts the writes to the store it wraps
+type countingStore struct {
+	types.KVStore
+	sets int
+}
+
+func (c *countingStore) Set(key, value []byte) {
+	c.sets++
+	c.KVStore.Set(key, value)
+}
+
+func TestVestingMints(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	plugin := New("cash")
+
+	addr1, addr2 := []byte("bigmoney"), []byte("litlefish")
+	plugin.SetOption(store, AddIssuer, hex.EncodeToString(addr1))
+	ctx := types.CallContext{CallerAddress: addr1}
+	balance := func() types.Coins {
+		acct := state.GetAccount(store, addr2)
+		if acct == nil {
+			return nil
+		}
+		return acct.Balance
+	}
+
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 10})
+	tx := MintTx{Credits{{
+		Addr:    addr2,
+		Amount:  types.Coins{{Denom: "USD", Amount: 100}},
+		Vesting: Vesting{Cliff: 20, End: 30},
+	}}}
+	res := plugin.RunTx(store, ctx, tx.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	assert.Nil(balance())
+	assert.Equal(int64(100), plugin.loadSupply(store, "USD").Total)
+	assert.Empty(plugin.loadVestingIDs(store))
+	assert.Equal([]uint64{0}, loadIDs(store, VestingCliffKey("cash", 20)))
+
+	// can't vest into the past
+	tx.Credits[0].Vesting = Vesting{End: 10}
+	res = plugin.RunTx(store, ctx, tx.Serialize())
+	assert.True(res.IsErr())
+
+	// nothing before the cliff, where the schedule is not even read
+	counting := &countingStore{KVStore: store}
+	plugin.BeginBlock(counting, nil, &abci.Header{Height: 19})
+	assert.Nil(balance())
+	assert.Equal(1, counting.sets, "only the height is stored")
+
+	// then linear
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 20})
+	assert.Equal(types.Coins{{Denom: "USD", Amount: 50}}, balance())
+	assert.Equal([]uint64{0}, plugin.loadVestingIDs(store))
+	assert.Empty(loadIDs(store, VestingCliffKey("cash", 20)))
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 21})
+	assert.Equal(types.Coins{{Denom: "USD", Amount: 55}}, balance())
+	v, err := plugin.loadVesting(store, 0)
+	if assert.Nil(err) {
+		assert.Equal(types.Coins{{Denom: "USD", Amount: 55}}, v.Released)
+	}
+
+	// a block which releases nothing writes nothing but the height
+	tx.Credits[0].Amount = types.Coins{{Denom: "USD", Amount: 1}}
+	tx.Credits[0].Vesting = Vesting{End: 30}
+	res = plugin.RunTx(store, ctx, tx.Serialize())
+	assert.True(res.IsOK(), res.Log)
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 22})
+	assert.Equal([]uint64{0, 1}, plugin.loadVestingIDs(store))
+	counting = &countingStore{KVStore: store}
+	plugin.BeginBlock(counting, nil, &abci.Header{Height: 23})
+	assert.Equal(types.Coins{{Denom: "USD", Amount: 65}}, balance())
+	assert.Equal(3, counting.sets, "the height, the account and the first schedule")
+
+	// and all of it at the end, after which the schedules are gone
+	plugin.BeginBlock(store, nil, &abci.Header{Height: 35})
+	assert.Equal(types.Coins{{Denom: "USD", Amount: 101}}, balance())
+	assert.Empty(plugin.loadVestingIDs(store))
+	_, err = plugin.loadVesting(store, 0)
+	assert.NotNil(err)
+}
@@ -0,0 +1,345 @@
+package mintcoin
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tendermint/basecoin/types"
+	wire "github.com/tendermint/go-wire"
+)
+
+// StateKey is where the legacy state was stored for the named plugin
+func StateKey(name string) []byte {
+	key := fmt.Sprintf("*%s*", name)
+	return []byte(key)
+}
+
+// IssuersKey is where the addresses of all issuers are stored
+func IssuersKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s/issuers", name))
+}
+
+// IssuerKey is where the Issuer with the given address is stored
+func IssuerKey(name string, addr []byte) []byte {
+	return append([]byte(fmt.Sprintf("%s/issuer/", name)), addr...)
+}
+
+// migrate moves all issuers out of the legacy blob, if any, into their
+// own keys. Legacy issuers could mint anything, so they get the Wildcard.
+func (mp MintPlugin) migrate(store types.KVStore) error {
+	data := store.Get(StateKey(mp.name))
+	if len(data) == 0 {
+		return nil
+	}
+
+	var s legacyMintState
+	err := wire.ReadBinaryBytes(data, &s)
+	if err != nil {
+		return fmt.Errorf("Error decoding legacy state: %v", err)
+	}
+	for _, addr := range s.Issuers {
+		issuer := mp.loadIssuer(store, addr)
+		issuer.Grant(Wildcard)
+		mp.saveIssuer(store, issuer)
+	}
+	store.Set(StateKey(mp.name), nil)
+	return nil
+}
+
+// loadIssuer returns the issuer at addr, with no permissions if unknown
+func (mp MintPlugin) loadIssuer(store types.KVStore, addr []byte) Issuer {
+	issuer := Issuer{Addr: addr}
+	data := store.Get(IssuerKey(mp.name, addr))
+	if len(data) == 0 {
+		return issuer
+	}
+	err := wire.ReadBinaryBytes(data, &issuer)
+	if err != nil {
+		panic(err)
+	}
+	return issuer
+}
+
+func (mp MintPlugin) isIssuer(store types.KVStore, addr []byte) bool {
+	return len(store.Get(IssuerKey(mp.name, addr))) > 0
+}
+
+// saveIssuer stores the issuer, or removes it once it may mint nothing
+func (mp MintPlugin) saveIssuer(store types.KVStore, issuer Issuer) {
+	if !issuer.IsIssuer() {
+		mp.removeIssuer(store, issuer.Addr)
+		return
+	}
+	// the list of issuers only changes when one is added
+	if !mp.isIssuer(store, issuer.Addr) {
+		addrs := append(mp.loadIssuerAddrs(store), issuer.Addr)
+		store.Set(IssuersKey(mp.name), wire.BinaryBytes(addrs))
+	}
+	store.Set(IssuerKey(mp.name, issuer.Addr), wire.BinaryBytes(issuer))
+}
+
+func (mp MintPlugin) removeIssuer(store types.KVStore, addr []byte) {
+	if !mp.isIssuer(store, addr) {
+		return
+	}
+	addrs := mp.loadIssuerAddrs(store)
+	for i := range addrs {
+		if bytes.Equal(addr, addrs[i]) {
+			addrs = append(addrs[:i], addrs[i+1:]...)
+			break
+		}
+	}
+	store.Set(IssuersKey(mp.name), wire.BinaryBytes(addrs))
+	store.Set(IssuerKey(mp.name, addr), nil)
+}
+
+func (mp MintPlugin) loadIssuerAddrs(store types.KVStore) [][]byte {
+	var addrs [][]byte
+	data := store.Get(IssuersKey(mp.name))
+	if len(data) == 0 {
+		return addrs
+	}
+	err := wire.ReadBinaryBytes(data, &addrs)
+	if err != nil {
+		panic(err)
+	}
+	return addrs
+}
+
+func (mp MintPlugin) configKey() []byte {
+	return []byte(fmt.Sprintf("%s/config", mp.name))
+}
+
+func (mp MintPlugin) loadConfig(store types.KVStore) MintConfig {
+	var c MintConfig
+	data := store.Get(mp.configKey())
+	if len(data) == 0 {
+		return c
+	}
+	err := wire.ReadBinaryBytes(data, &c)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (mp MintPlugin) saveConfig(store types.KVStore, config MintConfig) {
+	store.Set(mp.configKey(), wire.BinaryBytes(config))
+}
+
+// ProposalKey is where a pending Proposal is stored for the named plugin
+func ProposalKey(name string, id []byte) []byte {
+	return append([]byte(fmt.Sprintf("%s/proposal/", name)), id...)
+}
+
+func (mp MintPlugin) loadProposal(store types.KVStore, id []byte) Proposal {
+	var p Proposal
+	data := store.Get(ProposalKey(mp.name, id))
+	if len(data) == 0 {
+		return p
+	}
+	err := wire.ReadBinaryBytes(data, &p)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (mp MintPlugin) saveProposal(store types.KVStore, id []byte, p Proposal) {
+	store.Set(ProposalKey(mp.name, id), wire.BinaryBytes(p))
+}
+
+// RecordCountKey is where the number of MintRecords is stored for the named plugin
+func RecordCountKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s/mints", name))
+}
+
+// RecordKey is where the i-th MintRecord (counting from 0) is stored
+func RecordKey(name string, i uint64) []byte {
+	return []byte(fmt.Sprintf("%s/mints/%d", name, i))
+}
+
+// IssuerRecordCountKey is where the number of MintRecords by one issuer is stored
+func IssuerRecordCountKey(name string, addr []byte) []byte {
+	return []byte(fmt.Sprintf("%s/mints/issuer/%X", name, addr))
+}
+
+// IssuerRecordKey is where the position in the log of the i-th MintRecord
+// by one issuer is stored
+func IssuerRecordKey(name string, addr []byte, i uint64) []byte {
+	return []byte(fmt.Sprintf("%s/mints/issuer/%X/%d", name, addr, i))
+}
+
+// RecipientRecordCountKey is where the number of MintRecords to one recipient is stored
+func RecipientRecordCountKey(name string, addr []byte) []byte {
+	return []byte(fmt.Sprintf("%s/mints/recipient/%X", name, addr))
+}
+
+// RecipientRecordKey is where the position in the log of the i-th MintRecord
+// to one recipient is stored
+func RecipientRecordKey(name string, addr []byte, i uint64) []byte {
+	return []byte(fmt.Sprintf("%s/mints/recipient/%X/%d", name, addr, i))
+}
+
+func (mp MintPlugin) loadRecordCount(store types.KVStore) uint64 {
+	return loadCount(store, RecordCountKey(mp.name))
+}
+
+// loadCount reads a counter, which is 0 until it is first stored
+func loadCount(store types.KVStore, key []byte) uint64 {
+	var n uint64
+	data := store.Get(key)
+	if len(data) == 0 {
+		return n
+	}
+	err := wire.ReadBinaryBytes(data, &n)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func (mp MintPlugin) loadRecord(store types.KVStore, i uint64) (rec MintRecord, err error) {
+	data := store.Get(RecordKey(mp.name, i))
+	if len(data) == 0 {
+		return rec, fmt.Errorf("No mint record: %d", i)
+	}
+	err = wire.ReadBinaryBytes(data, &rec)
+	return rec, err
+}
+
+// appendRecord adds rec to the log, and to the indexes of the records
+// by its issuer and to its recipient
+func (mp MintPlugin) appendRecord(store types.KVStore, rec MintRecord) {
+	n := mp.loadRecordCount(store)
+	store.Set(RecordKey(mp.name, n), wire.BinaryBytes(rec))
+	store.Set(RecordCountKey(mp.name), wire.BinaryBytes(n+1))
+
+	i := loadCount(store, IssuerRecordCountKey(mp.name, rec.Issuer))
+	store.Set(IssuerRecordKey(mp.name, rec.Issuer, i), wire.BinaryBytes(n))
+	store.Set(IssuerRecordCountKey(mp.name, rec.Issuer), wire.BinaryBytes(i+1))
+	i = loadCount(store, RecipientRecordCountKey(mp.name, rec.Recipient))
+	store.Set(RecipientRecordKey(mp.name, rec.Recipient, i), wire.BinaryBytes(n))
+	store.Set(RecipientRecordCountKey(mp.name, rec.Recipient), wire.BinaryBytes(i+1))
+}
+
+// VestingIDsKey is where the ids of the unfinished VestingSchedules past
+// their cliff are stored, which are paid out every block
+func VestingIDsKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s/vesting", name))
+}
+
+// VestingCliffKey is where the ids of the VestingSchedules which start
+// releasing coins at height are stored, until they are paid out every block
+func VestingCliffKey(name string, height uint64) []byte {
+	return []byte(fmt.Sprintf("%s/vesting/cliff/%d", name, height))
+}
+
+func (mp MintPlugin) vestingHeightKey() []byte {
+	return []byte(fmt.Sprintf("%s/vesting/height", mp.name))
+}
+
+// VestingKey is where the VestingSchedule with the given id is stored
+func VestingKey(name string, id uint64) []byte {
+	return []byte(fmt.Sprintf("%s/vesting/%d", name, id))
+}
+
+func (mp MintPlugin) vestingCountKey() []byte {
+	return []byte(fmt.Sprintf("%s/vesting/count", mp.name))
+}
+
+func (mp MintPlugin) loadVestingIDs(store types.KVStore) []uint64 {
+	return loadIDs(store, VestingIDsKey(mp.name))
+}
+
+// loadIDs reads a list of ids, which is empty until it is first stored
+func loadIDs(store types.KVStore, key []byte) []uint64 {
+	var ids []uint64
+	data := store.Get(key)
+	if len(data) == 0 {
+		return ids
+	}
+	err := wire.ReadBinaryBytes(data, &ids)
+	if err != nil {
+		panic(err)
+	}
+	return ids
+}
+
+func (mp MintPlugin) loadVesting(store types.KVStore, id uint64) (v VestingSchedule, err error) {
+	data := store.Get(VestingKey(mp.name, id))
+	if len(data) == 0 {
+		return v, fmt.Errorf("No vesting schedule: %d", id)
+	}
+	err = wire.ReadBinaryBytes(data, &v)
+	return v, err
+}
+
+// addVesting stores a new schedule under the next free id, to be
+// looked at from the first height it may release anything
+func (mp MintPlugin) addVesting(store types.KVStore, v VestingSchedule) uint64 {
+	var id uint64
+	if data := store.Get(mp.vestingCountKey()); len(data) > 0 {
+		err := wire.ReadBinaryBytes(data, &id)
+		if err != nil {
+			panic(err)
+		}
+	}
+	store.Set(mp.vestingCountKey(), wire.BinaryBytes(id+1))
+	store.Set(VestingKey(mp.name, id), wire.BinaryBytes(v))
+
+	release := v.Cliff
+	if release <= v.Start {
+		release = v.Start + 1
+	}
+	ids := append(loadIDs(store, VestingCliffKey(mp.name, release)), id)
+	store.Set(VestingCliffKey(mp.name, release), wire.BinaryBytes(ids))
+	return id
+}
+
+// SupplyKey is where the Supply of denom is stored for the named plugin
+func SupplyKey(name, denom string) []byte {
+	return []byte(fmt.Sprintf("%s/supply/%s", name, denom))
+}
+
+// DenomsKey is where the list of all denoms with a Supply is stored
+func DenomsKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s/denoms", name))
+}
+
+func (mp MintPlugin) loadSupply(store types.KVStore, denom string) Supply {
+	s := Supply{Denom: denom}
+	data := store.Get(SupplyKey(mp.name, denom))
+	if len(data) == 0 {
+		return s
+	}
+	err := wire.ReadBinaryBytes(data, &s)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (mp MintPlugin) saveSupply(store types.KVStore, supply Supply) {
+	key := SupplyKey(mp.name, supply.Denom)
+	// keep an index of all denoms, so they can be listed
+	if len(store.Get(key)) == 0 {
+		denoms := mp.loadDenoms(store)
+		denoms = append(denoms, supply.Denom)
+		store.Set(DenomsKey(mp.name), wire.BinaryBytes(denoms))
+	}
+	store.Set(key, wire.BinaryBytes(supply))
+}
+
+func (mp MintPlugin) loadDenoms(store types.KVStore) []string {
+	var denoms []string
+	data := store.Get(DenomsKey(mp.name))
+	if len(data) == 0 {
+		return denoms
+	}
+	err := wire.ReadBinaryBytes(data, &denoms)
+	if err != nil {
+		panic(err)
+	}
+	return denoms
+}
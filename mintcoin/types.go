@@ -2,42 +2,197 @@ package mintcoin
 
 import (
 	"bytes"
+	"math"
+	"math/big"
 
 	"github.com/tendermint/basecoin/types"
 	wire "github.com/tendermint/go-wire"
+	"golang.org/x/crypto/ripemd160"
 )
 
-type MintState struct {
-	Issuers Issuers
+func init() {
+	// register tx implementations with gowire
+	wire.RegisterInterface(
+		txwrap{},
+		wire.ConcreteType{O: MintTx{}, Byte: 0x01},
+		wire.ConcreteType{O: BurnTx{}, Byte: 0x02},
+		wire.ConcreteType{O: AddIssuerTx{}, Byte: 0x03},
+		wire.ConcreteType{O: RemoveIssuerTx{}, Byte: 0x04},
+	)
 }
 
-type Issuer []byte
+// Tx is any transaction the MintPlugin understands
+type Tx interface{}
+
+type txwrap struct {
+	Tx
+}
+
+func ParseTx(data []byte) (Tx, error) {
+	holder := txwrap{}
+	err := wire.ReadBinaryBytes(data, &holder)
+	return holder.Tx, err
+}
+
+func TxBytes(tx Tx) []byte {
+	return wire.BinaryBytes(txwrap{tx})
+}
+
+// Wildcard may be granted to an issuer to allow minting any denomination
+const Wildcard = "*"
+
+// legacyMintState is the legacy format, where the addresses of all issuers
+// were stored in one blob under StateKey.  It is only read to migrate to
+// one key per issuer.
+type legacyMintState struct {
+	Issuers [][]byte
+}
+
+// Issuer is an address that may mint coins of the listed denominations
+type Issuer struct {
+	Addr       []byte
+	Denoms     []string
+	Allowances []Allowance
+}
 
 type Issuers []Issuer
 
-func (s *MintState) AddIssuer(addr []byte) {
-	if !s.IsIssuer(addr) {
-		s.Issuers = append(s.Issuers, addr)
+// Allowance limits how much of one denomination an issuer may mint
+// within one allowance period
+type Allowance struct {
+	Denom  string
+	Limit  int64
+	Used   int64
+	Period uint64 // the period in which Used was minted
+}
+
+// Remaining returns how much may still be minted in the given period
+func (a Allowance) Remaining(period uint64) int64 {
+	if a.Period != period {
+		return a.Limit
+	}
+	return a.Limit - a.Used
+}
+
+// IsIssuer returns true as long as the issuer may mint anything
+func (i Issuer) IsIssuer() bool {
+	return len(i.Denoms) > 0
+}
+
+// CanIssue returns true if this issuer may mint the given denomination
+func (i Issuer) CanIssue(denom string) bool {
+	for _, d := range i.Denoms {
+		if d == Wildcard || d == denom {
+			return true
+		}
 	}
+	return false
 }
 
-func (s *MintState) RemoveIssuer(addr []byte) {
-	b := s.Issuers
-	for i := range b {
-		if bytes.Equal(addr, b[i]) {
-			s.Issuers = append(b[:i], b[i+1:]...)
+// Grant allows the issuer to mint denom
+func (i *Issuer) Grant(denom string) {
+	for _, d := range i.Denoms {
+		if d == denom {
 			return
 		}
 	}
+	i.Denoms = append(i.Denoms, denom)
 }
 
-func (s *MintState) IsIssuer(addr []byte) bool {
-	for _, b := range s.Issuers {
-		if bytes.Equal(b, addr) {
-			return true
+// Revoke removes the permission to mint denom.  Note that revoking
+// a single denom from an issuer holding the Wildcard has no effect
+// on what they can mint.
+func (i *Issuer) Revoke(denom string) {
+	for j, d := range i.Denoms {
+		if d == denom {
+			i.Denoms = append(i.Denoms[:j], i.Denoms[j+1:]...)
+			return
 		}
 	}
-	return false
+}
+
+// SetAllowance limits how much of denom may be minted per period,
+// a zero limit removes the allowance
+func (i *Issuer) SetAllowance(denom string, limit int64) {
+	for j := range i.Allowances {
+		if i.Allowances[j].Denom == denom {
+			if limit == 0 {
+				i.Allowances = append(i.Allowances[:j], i.Allowances[j+1:]...)
+			} else {
+				i.Allowances[j].Limit = limit
+			}
+			return
+		}
+	}
+	if limit != 0 {
+		i.Allowances = append(i.Allowances, Allowance{Denom: denom, Limit: limit})
+	}
+}
+
+// Spend uses amount of this issuer's allowance for denom in period,
+// returning false if not enough is left.  Denoms without an allowance
+// are unlimited.
+func (i *Issuer) Spend(denom string, amount int64, period uint64) bool {
+	for j := range i.Allowances {
+		a := &i.Allowances[j]
+		if a.Denom != denom {
+			continue
+		}
+		if a.Remaining(period) < amount {
+			return false
+		}
+		if a.Period != period {
+			a.Period, a.Used = period, 0
+		}
+		a.Used += amount
+		return true
+	}
+	return true
+}
+
+// MintConfig holds plugin wide settings
+type MintConfig struct {
+	RestrictBurn    bool   // if true, only issuers of a denom may burn it
+	Quorum          int    // approvals needed to change issuers (0 = majority)
+	AllowancePeriod uint64 // blocks until allowances replenish (0 = never)
+}
+
+// Period returns the allowance period the given height belongs to
+func (c MintConfig) Period(height uint64) uint64 {
+	if c.AllowancePeriod == 0 {
+		return 0
+	}
+	return height / c.AllowancePeriod
+}
+
+// Required returns how many approvals a Proposal needs to pass,
+// given the current number of issuers
+func (c MintConfig) Required(issuers int) int {
+	switch {
+	case c.Quorum == 0:
+		return issuers/2 + 1
+	case c.Quorum > issuers:
+		return issuers
+	default:
+		return c.Quorum
+	}
+}
+
+// Supply tracks how many coins of one denomination have been minted
+type Supply struct {
+	Denom string
+	Total int64
+	Cap   int64 // maximum Total that may be minted (0 = unlimited)
+}
+
+// Overflows returns true if amount more coins don't fit in the Total
+func (s Supply) Overflows(amount int64) bool {
+	return amount > math.MaxInt64-s.Total
+}
+
+// CanMint returns true if amount more coins fit under the cap
+func (s Supply) CanMint(amount int64) bool {
+	return s.Cap == 0 || amount <= s.Cap-s.Total
 }
 
 type MintTx struct {
@@ -47,10 +202,128 @@ type MintTx struct {
 type Credits []Credit
 
 type Credit struct {
-	Addr   []byte
-	Amount types.Coins
+	Addr    []byte
+	Amount  types.Coins
+	Vesting Vesting // optional, to lock the Amount for a while
+}
+
+// Vesting locks minted coins, which are then released linearly from the
+// height of the mint until End, but nothing is released before Cliff
+type Vesting struct {
+	Cliff uint64
+	End   uint64
+}
+
+func (v Vesting) IsZero() bool {
+	return v.Cliff == 0 && v.End == 0
+}
+
+// IsValid returns true if there is no vesting, or it ends after height
+func (v Vesting) IsValid(height uint64) bool {
+	return v.IsZero() || (v.End > height && v.Cliff <= v.End)
+}
+
+// VestingSchedule holds locked coins until they are released to Recipient
+type VestingSchedule struct {
+	Recipient []byte
+	Start     uint64 // height of the mint
+	Vesting
+	Total    types.Coins
+	Released types.Coins
+}
+
+// Vested returns how much of Total is unlocked at the given height
+func (v VestingSchedule) Vested(height uint64) types.Coins {
+	switch {
+	case height < v.Cliff || height <= v.Start:
+		return nil
+	case height >= v.End:
+		return v.Total
+	}
+
+	// Total * elapsed / duration, without overflowing
+	elapsed := new(big.Int).SetUint64(height - v.Start)
+	duration := new(big.Int).SetUint64(v.End - v.Start)
+	var vested types.Coins
+	for _, coin := range v.Total {
+		amount := new(big.Int).Mul(big.NewInt(coin.Amount), elapsed)
+		amount.Quo(amount, duration)
+		if amount.Sign() > 0 {
+			vested = append(vested, types.Coin{Denom: coin.Denom, Amount: amount.Int64()})
+		}
+	}
+	return vested
 }
 
 func (tx MintTx) Serialize() []byte {
-	return wire.BinaryBytes(tx)
+	return TxBytes(tx)
+}
+
+// MintRecord is one entry in the audit log of all successful mints
+type MintRecord struct {
+	Height    uint64
+	Issuer    []byte
+	Recipient []byte
+	Amount    types.Coins
+}
+
+// BurnTx destroys all coins sent along with the AppTx
+type BurnTx struct{}
+
+func (tx BurnTx) Serialize() []byte {
+	return TxBytes(tx)
+}
+
+// AddIssuerTx proposes to let Addr mint Denoms.  It is executed once
+// enough issuers have sent the same tx.
+type AddIssuerTx struct {
+	Addr   []byte
+	Denoms []string
+}
+
+func (tx AddIssuerTx) Serialize() []byte {
+	return TxBytes(tx)
+}
+
+// RemoveIssuerTx proposes to take away all minting rights from Addr.
+// It is executed once enough issuers have sent the same tx.
+type RemoveIssuerTx struct {
+	Addr []byte
+}
+
+func (tx RemoveIssuerTx) Serialize() []byte {
+	return TxBytes(tx)
+}
+
+// Proposal is a pending AddIssuerTx or RemoveIssuerTx waiting for approvals
+type Proposal struct {
+	Tx        Tx
+	Approvals [][]byte // issuers who sent this tx
+}
+
+// ProposalID is the ripemd160 hash of the proposed tx, which is constant
+func ProposalID(tx Tx) []byte {
+	hasher := ripemd160.New()
+	hasher.Write(TxBytes(tx))
+	return hasher.Sum(nil)
+}
+
+func (p Proposal) HasApproved(addr []byte) bool {
+	for _, a := range p.Approvals {
+		if bytes.Equal(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of approvals from current issuers
+func (p Proposal) Count(isIssuer func(addr []byte) bool) int {
+	n := 0
+	for _, a := range p.Approvals {
+		if isIssuer(a) {
+			n++
+		}
+	}
+	return n
 }
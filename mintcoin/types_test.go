@@ -4,29 +4,91 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/basecoin/types"
 )
 
-func TestState(t *testing.T) {
+func TestIssuer(t *testing.T) {
 	assert := assert.New(t)
-	addr1 := []byte("foobar")
-	addr2 := []byte("biggie")
-
-	s := MintState{}
-	assert.False(s.IsIssuer(addr1))
-	assert.False(s.IsIssuer(addr2))
-
-	s.AddIssuer(addr1)
-	assert.True(s.IsIssuer(addr1))
-	assert.False(s.IsIssuer(addr2))
-
-	s.AddIssuer(addr2)
-	assert.True(s.IsIssuer(addr1))
-	assert.True(s.IsIssuer(addr2))
-
-	// make sure multiple adds don't lead to multiple entries
-	s.AddIssuer(addr1)
-	s.AddIssuer(addr1)
-	s.RemoveIssuer(addr1)
-	assert.False(s.IsIssuer(addr1))
-	assert.True(s.IsIssuer(addr2))
+
+	i := Issuer{Addr: []byte("foobar")}
+	assert.False(i.IsIssuer())
+	i.Grant("USD")
+	assert.True(i.IsIssuer())
+	assert.True(i.CanIssue("USD"))
+	assert.False(i.CanIssue("EUR"))
+
+	// duplicate grants are ignored, revoke drops just one denom
+	i.Grant("EUR")
+	i.Grant("EUR")
+	i.Revoke("USD")
+	assert.False(i.CanIssue("USD"))
+	assert.True(i.CanIssue("EUR"))
+
+	// removing the last permission removes the issuer
+	i.Revoke("EUR")
+	assert.False(i.IsIssuer())
+
+	// the wildcard allows everything, until it is revoked itself
+	i.Grant(Wildcard)
+	assert.True(i.CanIssue("USD"))
+	assert.True(i.CanIssue("EUR"))
+	i.Revoke("USD")
+	assert.True(i.CanIssue("USD"))
+	i.Revoke(Wildcard)
+	assert.False(i.IsIssuer())
+}
+
+func TestAllowances(t *testing.T) {
+	assert := assert.New(t)
+
+	i := Issuer{Addr: []byte("foobar"), Denoms: []string{Wildcard}}
+	i.SetAllowance("USD", 100)
+
+	// other denoms are unlimited
+	assert.True(i.Spend("EUR", 5000, 0))
+
+	// usd can only be minted up to the limit per period
+	assert.True(i.Spend("USD", 60, 0))
+	assert.False(i.Spend("USD", 41, 0))
+	assert.True(i.Spend("USD", 40, 0))
+	assert.False(i.Spend("USD", 1, 0))
+
+	// and replenishes in the next period
+	assert.True(i.Spend("USD", 100, 1))
+	assert.False(i.Spend("USD", 1, 1))
+
+	// raising the limit keeps what was used
+	i.SetAllowance("USD", 150)
+	assert.False(i.Spend("USD", 51, 1))
+	assert.True(i.Spend("USD", 50, 1))
+
+	// a zero limit removes it
+	i.SetAllowance("USD", 0)
+	assert.Empty(i.Allowances)
+	assert.True(i.Spend("USD", 1000, 1))
+}
+
+func TestVested(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(Vesting{}.IsZero())
+	assert.True(Vesting{}.IsValid(100))
+	assert.True(Vesting{Cliff: 50, End: 200}.IsValid(100))
+	assert.False(Vesting{Cliff: 50, End: 100}.IsValid(100))
+	assert.False(Vesting{Cliff: 300, End: 200}.IsValid(100))
+
+	v := VestingSchedule{
+		Start:   100,
+		Vesting: Vesting{Cliff: 150, End: 200},
+		Total: types.Coins{
+			{Denom: "BTC", Amount: 10},
+			{Denom: "USD", Amount: 1000},
+		},
+	}
+	assert.Nil(v.Vested(100))
+	assert.Nil(v.Vested(149))
+	assert.Equal(types.Coins{{Denom: "BTC", Amount: 5}, {Denom: "USD", Amount: 500}}, v.Vested(150))
+	assert.Equal(types.Coins{{Denom: "BTC", Amount: 8}, {Denom: "USD", Amount: 870}}, v.Vested(187))
+	assert.Equal(v.Total, v.Vested(200))
+	assert.Equal(v.Total, v.Vested(5000))
 }
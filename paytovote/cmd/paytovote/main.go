@@ -3,7 +3,8 @@ package main
 import (
 	"os"
 
-	_ "github.com/tendermint/basecoin-examples/paytovote/commands"
+	// importing registers the paytovote plugin to apptx
+	pcmd "github.com/tendermint/basecoin-examples/paytovote/commands"
 	"github.com/tendermint/basecoin/cmd/commands"
 	"github.com/urfave/cli"
 )
@@ -18,6 +19,7 @@ func main() {
 		commands.TxCmd,
 		commands.QueryCmd,
 		commands.AccountCmd,
+		pcmd.ListCmd,
 	}
 	app.Run(os.Args)
 }
@@ -1,7 +1,10 @@
 package commands
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/tendermint/basecoin-examples/paytovote"
 	bcmd "github.com/tendermint/basecoin/cmd/commands"
@@ -12,7 +15,12 @@ import (
 const PaytovoteName = "paytovote"
 
 var (
-	//common flag
+	//common flags
+	PluginFlag = cli.StringFlag{
+		Name:  "plugin",
+		Value: PaytovoteName,
+		Usage: "name of the paytovote plugin to use, when the chain runs several",
+	}
 	IssueFlag = cli.StringFlag{
 		Name:  "issue",
 		Value: "default issue",
@@ -31,11 +39,125 @@ var (
 		Usage: "the fee amount of coin type VoteCoinFlag to vote for the issue",
 	}
 
+	EndHeightFlag = cli.IntFlag{
+		Name:  "endHeight",
+		Value: 0,
+		Usage: "the last block height to accept votes for the issue, 0 to never close",
+	}
+
+	QuorumFlag = cli.IntFlag{
+		Name:  "quorum",
+		Value: 0,
+		Usage: "the minimum number of votes needed for the outcome to count",
+	}
+	ThresholdFlag = cli.StringFlag{
+		Name:  "threshold",
+		Value: "",
+		Usage: "the share of votes needed to pass, such as 2/3, or empty for a simple majority",
+	}
+
+	WeightedFlag = cli.BoolFlag{
+		Name:  "weighted",
+		Usage: "weigh each vote by the amount of weightDenom paid above the vote fee",
+	}
+	QuadraticFlag = cli.BoolFlag{
+		Name:  "quadratic",
+		Usage: "charge voters N^2 times the vote fee for casting N votes",
+	}
+	OneBallotFlag = cli.BoolFlag{
+		Name:  "oneBallot",
+		Usage: "allow each address a single ballot, which it may change until voting closes",
+	}
+	ChoicesFlag = cli.StringFlag{
+		Name:  "choices",
+		Value: "",
+		Usage: "comma separated names of the choices of a multiple choice issue, which may include abstain",
+	}
+	RankedFlag = cli.BoolFlag{
+		Name:  "ranked",
+		Usage: "have voters rank the choices and decide the issue by instant-runoff",
+	}
+	BeneficiaryFlag = cli.StringFlag{
+		Name:  "beneficiary",
+		Value: "",
+		Usage: "hex address to receive the vote fees, instead of the plugin's beneficiary",
+	}
+	SettlementFlag = cli.StringFlag{
+		Name:  "settlement",
+		Value: "",
+		Usage: "hold the vote fees of a oneBallot issue until it closes, then refund-losers or refund-winners",
+	}
+	WeightDenomFlag = cli.StringFlag{
+		Name:  "weightDenom",
+		Value: "",
+		Usage: "the coin type which weighs votes on a weighted issue",
+	}
+
+	RevealHeightFlag = cli.IntFlag{
+		Name:  "revealHeight",
+		Value: 0,
+		Usage: "keep ballots secret until endHeight, then accept reveals up to this block height",
+	}
+
+	DescriptionFlag = cli.StringFlag{
+		Name:  "description",
+		Value: "",
+		Usage: "what the issue proposes",
+	}
+	LinkFlag = cli.StringFlag{
+		Name:  "link",
+		Value: "",
+		Usage: "where the proposal can be read, such as a URL",
+	}
+	ContentHashFlag = cli.StringFlag{
+		Name:  "contentHash",
+		Value: "",
+		Usage: "hex hash of an off-chain proposal document",
+	}
+
+	CreateFeeFlag = cli.StringFlag{
+		Name:  "createFee",
+		Value: "1issueToken",
+		Usage: "the most to pay to create the issue, at least the chain's create-fee",
+	}
+
 	//vote flag
 	VoteForFlag = cli.BoolFlag{
 		Name:  "voteFor",
 		Usage: "set to true when vote be cast is a vote-for the issue, false if vote-against",
 	}
+	VotesFlag = cli.IntFlag{
+		Name:  "votes",
+		Value: 1,
+		Usage: "the number of votes to cast on a quadratic issue",
+	}
+	ChoiceFlag = cli.StringFlag{
+		Name:  "choice",
+		Value: "",
+		Usage: "the choice to vote for on a multiple choice issue, instead of voteFor",
+	}
+	RankingFlag = cli.StringFlag{
+		Name:  "ranking",
+		Value: "",
+		Usage: "comma separated choices in order of preference on a ranked issue",
+	}
+	SaltFlag = cli.StringFlag{
+		Name:  "salt",
+		Value: "",
+		Usage: "secret to commit to the ballot with on a secret issue, and later reveal it with",
+	}
+
+	//delegate flags
+	DelegateFlag = cli.StringFlag{
+		Name:  "delegate",
+		Value: "",
+		Usage: "hex address whose ballot counts for you when you do not vote, empty to revoke",
+	}
+	DelegateIssueFlag = cli.StringFlag{
+		Name:  "issue",
+		Value: "",
+		Usage: "name of the issue to delegate on, empty for all issues",
+	}
 )
 
 var (
@@ -45,6 +167,8 @@ var (
 		Subcommands: []cli.Command{
 			P2VCreateIssueCmd,
 			P2VVoteCmd,
+			P2VRevealCmd,
+			P2VDelegateCmd,
 		},
 	}
 
@@ -55,9 +179,26 @@ var (
 			return cmdCreateIssue(c)
 		},
 		Flags: append(bcmd.TxFlags,
+			PluginFlag,
 			IssueFlag,
 			VoteFeeCoinFlag,
 			VoteFeeAmtFlag,
+			CreateFeeFlag,
+			EndHeightFlag,
+			QuorumFlag,
+			ThresholdFlag,
+			WeightedFlag,
+			WeightDenomFlag,
+			QuadraticFlag,
+			OneBallotFlag,
+			ChoicesFlag,
+			RankedFlag,
+			BeneficiaryFlag,
+			SettlementFlag,
+			RevealHeightFlag,
+			DescriptionFlag,
+			LinkFlag,
+			ContentHashFlag,
 		),
 	}
 
@@ -68,8 +209,42 @@ var (
 			return cmdVote(c)
 		},
 		Flags: append(bcmd.TxFlags,
+			PluginFlag,
+			IssueFlag,
+			VoteForFlag,
+			VotesFlag,
+			ChoiceFlag,
+			RankingFlag,
+			SaltFlag,
+		),
+	}
+
+	P2VRevealCmd = cli.Command{
+		Name:  "reveal",
+		Usage: "Reveal a ballot committed to on a secret issue",
+		Action: func(c *cli.Context) error {
+			return cmdReveal(c)
+		},
+		Flags: append(bcmd.TxFlags,
+			PluginFlag,
 			IssueFlag,
 			VoteForFlag,
+			ChoiceFlag,
+			RankingFlag,
+			SaltFlag,
+		),
+	}
+
+	P2VDelegateCmd = cli.Command{
+		Name:  "delegate",
+		Usage: "Hand your voice to a delegate on one or all issues",
+		Action: func(c *cli.Context) error {
+			return cmdDelegate(c)
+		},
+		Flags: append(bcmd.TxFlags,
+			PluginFlag,
+			DelegateIssueFlag,
+			DelegateFlag,
 		),
 	}
 )
@@ -77,7 +252,7 @@ var (
 func init() {
 	bcmd.RegisterTxSubcommand(P2VCmd)
 	bcmd.RegisterStartPlugin(PaytovoteName,
-		func() types.Plugin { return paytovote.New() })
+		func() types.Plugin { return paytovote.New(PaytovoteName) })
 }
 
 func cmdCreateIssue(c *cli.Context) error {
@@ -86,25 +261,171 @@ func cmdCreateIssue(c *cli.Context) error {
 	feeAmt := int64(c.Int(VoteFeeAmtFlag.Name))
 
 	voteFee := types.Coins{{feeCoin, feeAmt}}
-	createIssueFee := types.Coins{{"issueToken", 1}} //manually set the cost to create a new issue
+	createIssueFee, err := bcmd.ParseCoins(c.String(CreateFeeFlag.Name))
+	if err != nil {
+		return err
+	}
 
-	txBytes := paytovote.NewCreateIssueTxBytes(issue, voteFee, createIssueFee)
+	endHeight := c.Int(EndHeightFlag.Name)
+	if endHeight < 0 {
+		return errors.New("endHeight cannot be negative")
+	}
+	revealHeight := c.Int(RevealHeightFlag.Name)
+	if revealHeight < 0 {
+		return errors.New("revealHeight cannot be negative")
+	}
+
+	threshold, err := parseThreshold(c.String(ThresholdFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	var settlement byte
+	switch c.String(SettlementFlag.Name) {
+	case "":
+		settlement = paytovote.SettleNone
+	case "refund-losers":
+		settlement = paytovote.SettleRefundLosers
+	case "refund-winners":
+		settlement = paytovote.SettleRefundWinners
+	default:
+		return errors.New("settlement must be refund-losers or refund-winners")
+	}
+
+	beneficiary, err := hex.DecodeString(bcmd.StripHex(c.String(BeneficiaryFlag.Name)))
+	if err != nil {
+		return fmt.Errorf("beneficiary is invalid hex: %v", err)
+	}
+	contentHash, err := hex.DecodeString(bcmd.StripHex(c.String(ContentHashFlag.Name)))
+	if err != nil {
+		return fmt.Errorf("contentHash is invalid hex: %v", err)
+	}
+
+	mode := paytovote.ModeCount
+	switch {
+	case c.Bool(WeightedFlag.Name) && c.Bool(QuadraticFlag.Name):
+		return errors.New("an issue cannot be both weighted and quadratic")
+	case c.Bool(WeightedFlag.Name) && c.String(WeightDenomFlag.Name) == "":
+		return errors.New("a weighted issue needs --weightDenom")
+	case c.Bool(WeightedFlag.Name):
+		mode = paytovote.ModeWeighted
+	case c.String(WeightDenomFlag.Name) != "":
+		return errors.New("--weightDenom is only used with --weighted")
+	case c.Bool(QuadraticFlag.Name):
+		mode = paytovote.ModeQuadratic
+	}
+
+	txBytes := paytovote.NewCreateIssueTxBytesFrom(paytovote.CreateIssueTx{
+		Issue:           issue,
+		FeePerVote:      voteFee,
+		Fee2CreateIssue: createIssueFee,
+		EndHeight:       uint64(endHeight),
+		Quorum:          c.Int(QuorumFlag.Name),
+		Threshold:       threshold,
+		Mode:            mode,
+		WeightDenom:     c.String(WeightDenomFlag.Name),
+		OneBallot:       c.Bool(OneBallotFlag.Name),
+		Choices:         splitNames(c.String(ChoicesFlag.Name)),
+		Ranked:          c.Bool(RankedFlag.Name),
+		Beneficiary:     beneficiary,
+		Settlement:      settlement,
+		RevealHeight:    uint64(revealHeight),
+		Description:     c.String(DescriptionFlag.Name),
+		Link:            c.String(LinkFlag.Name),
+		ContentHash:     contentHash,
+	})
 
 	fmt.Println("Issue creation transaction sent")
-	return bcmd.AppTx(c, PaytovoteName, txBytes)
+	return bcmd.AppTx(c, c.String(PluginFlag.Name), txBytes)
 }
 
 func cmdVote(c *cli.Context) error {
-	issue := c.String(IssueFlag.Name)
-	voteFor := c.Bool(VoteForFlag.Name)
+	tx := paytovote.VoteTx{
+		Issue: c.String(IssueFlag.Name),
+		Votes: c.Int(VotesFlag.Name),
+	}
+
+	// On a secret issue only the commitment to the ballot is sent,
+	// which is bound to the address of the voter signing the tx
+	ballot := revealTx(c)
+	if len(ballot.Salt) > 0 {
+		voter := bcmd.LoadKey(c.String("from")).Address
+		tx.Commitment = ballot.Commitment(voter[:])
+	} else {
+		tx.VoteTypeByte, tx.Choice, tx.Ranking = ballot.VoteTypeByte, ballot.Choice, ballot.Ranking
+	}
+
+	txBytes := paytovote.NewVoteTxBytesFrom(tx)
+	fmt.Println("Vote transaction sent")
+	return bcmd.AppTx(c, c.String(PluginFlag.Name), txBytes)
+}
+
+func cmdReveal(c *cli.Context) error {
+	tx := revealTx(c)
+	if len(tx.Salt) == 0 {
+		return errors.New("salt is needed to reveal a ballot")
+	}
+
+	txBytes := paytovote.NewRevealTxBytes(tx)
+	fmt.Println("Reveal transaction sent")
+	return bcmd.AppTx(c, c.String(PluginFlag.Name), txBytes)
+}
+
+func cmdDelegate(c *cli.Context) error {
+	delegate, err := hex.DecodeString(bcmd.StripHex(c.String(DelegateFlag.Name)))
+	if err != nil {
+		return fmt.Errorf("delegate is invalid hex: %v", err)
+	}
+
+	txBytes := paytovote.NewDelegateTxBytes(paytovote.DelegateTx{
+		Issue:    c.String(DelegateIssueFlag.Name),
+		Delegate: delegate,
+	})
+
+	fmt.Println("Delegate transaction sent")
+	return bcmd.AppTx(c, c.String(PluginFlag.Name), txBytes)
+}
+
+// revealTx reads the ballot from the flags shared by vote and reveal
+func revealTx(c *cli.Context) paytovote.RevealTx {
+	choice := c.String(ChoiceFlag.Name)
 
 	var voteTB byte = paytovote.TypeByteVoteFor
-	if !voteFor {
+	if len(choice) > 0 {
+		voteTB = paytovote.TypeByteVoteChoice
+	} else if !c.Bool(VoteForFlag.Name) {
 		voteTB = paytovote.TypeByteVoteAgainst
 	}
 
-	txBytes := paytovote.NewVoteTxBytes(issue, voteTB)
+	return paytovote.RevealTx{
+		Issue:        c.String(IssueFlag.Name),
+		VoteTypeByte: voteTB,
+		Choice:       choice,
+		Ranking:      splitNames(c.String(RankingFlag.Name)),
+		Salt:         []byte(c.String(SaltFlag.Name)),
+	}
+}
 
-	fmt.Println("Vote transaction sent")
-	return bcmd.AppTx(c, PaytovoteName, txBytes)
+// parseThreshold reads a fraction such as "2/3", empty meaning a simple majority
+func parseThreshold(str string) (threshold paytovote.Threshold, err error) {
+	if len(str) == 0 {
+		return
+	}
+	n, err := fmt.Sscanf(str, "%d/%d", &threshold.Num, &threshold.Den)
+	if err != nil || n != 2 || !threshold.IsValid() {
+		return threshold, fmt.Errorf("threshold must be a fraction such as 2/3, got %q", str)
+	}
+	return threshold, nil
+}
+
+// splitNames reads a comma separated list, empty meaning none
+func splitNames(str string) []string {
+	if len(str) == 0 {
+		return nil
+	}
+	names := strings.Split(str, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
 }
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tendermint/basecoin-examples/paytovote"
+	bcmd "github.com/tendermint/basecoin/cmd/commands"
+	wire "github.com/tendermint/go-wire"
+	"github.com/urfave/cli"
+)
+
+var (
+	IssueQueryCmd = cli.Command{
+		Name:      "issue",
+		Usage:     "Show the tallies and status of an issue",
+		ArgsUsage: "<name>",
+		Action: func(c *cli.Context) error {
+			return cmdIssueQuery(c)
+		},
+		Flags: []cli.Flag{
+			bcmd.NodeFlag,
+			PluginFlag,
+		},
+	}
+
+	ListCmd = cli.Command{
+		Name:  "list",
+		Usage: "List all issues with their tallies and status",
+		Action: func(c *cli.Context) error {
+			return cmdList(c)
+		},
+		Flags: []cli.Flag{
+			bcmd.NodeFlag,
+			PluginFlag,
+		},
+	}
+)
+
+func init() {
+	bcmd.QueryCmd.Subcommands = append(bcmd.QueryCmd.Subcommands, IssueQueryCmd)
+}
+
+// issueResult is a P2VIssue along with its status spelled out
+type issueResult struct {
+	Status    string             `json:"status"`
+	VotesCast int                `json:"votes_cast"`
+	Issue     paytovote.P2VIssue `json:"issue"`
+}
+
+func cmdIssueQuery(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return errors.New("issue command requires an argument (<name>)")
+	}
+	result, err := queryIssue(c.String("node"), c.String(PluginFlag.Name), c.Args()[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(wire.JSONBytes(result)))
+	return nil
+}
+
+func cmdList(c *cli.Context) error {
+	tmAddr, name := c.String("node"), c.String(PluginFlag.Name)
+	var issues []string
+	err := queryValue(tmAddr, name, paytovote.IssuesKey(), &issues)
+	if err != nil {
+		return err
+	}
+
+	results := make([]issueResult, len(issues))
+	for i, issue := range issues {
+		results[i], err = queryIssue(tmAddr, name, issue)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(string(wire.JSONBytes(results)))
+	return nil
+}
+
+func queryIssue(tmAddr, name, issue string) (result issueResult, err error) {
+	key := paytovote.IssueKey(issue)
+	value, err := queryBytes(tmAddr, name, key)
+	if err != nil {
+		return
+	}
+	if len(value) == 0 {
+		return result, fmt.Errorf("Issue not found: %s", issue)
+	}
+	result.Issue, err = paytovote.DecodeIssue(value)
+	if err != nil {
+		return result, fmt.Errorf("Error reading %s: %v", key, err)
+	}
+	result.Status = statusName(result.Issue.Status)
+	result.VotesCast = result.Issue.VotesCast()
+	return result, nil
+}
+
+func statusName(status byte) string {
+	switch status {
+	case paytovote.StatusOpen:
+		return "open"
+	case paytovote.StatusPassed:
+		return "passed"
+	case paytovote.StatusRejected:
+		return "rejected"
+	case paytovote.StatusNoQuorum:
+		return "no quorum"
+	}
+	return fmt.Sprintf("unknown (%d)", status)
+}
+
+// queryValue reads the go-wire encoded value at key of the named plugin
+// into ptr, leaving it untouched if nothing is stored there
+func queryValue(tmAddr, name string, key []byte, ptr interface{}) error {
+	value, err := queryBytes(tmAddr, name, key)
+	if err != nil || len(value) == 0 {
+		return err
+	}
+	err = wire.ReadBinaryBytes(value, ptr)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", key, err)
+	}
+	return nil
+}
+
+// queryBytes reads the raw value at key of the named plugin
+func queryBytes(tmAddr, name string, key []byte) ([]byte, error) {
+	response, err := bcmd.Query(tmAddr, paytovote.PrefixedKey(name, key))
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Value) == 0 && name == paytovote.LegacyName {
+		// the plugin has not yet moved the value under its prefix
+		response, err = bcmd.Query(tmAddr, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response.Value, nil
+}
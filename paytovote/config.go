@@ -0,0 +1,146 @@
+package paytovote
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tendermint/basecoin/types"
+	cmn "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+)
+
+// Keys accepted by SetOption
+const (
+	OptionBeneficiary    = "beneficiary"      //hex address, empty to burn fees
+	OptionCreateFee      = "create-fee"       //coins such as 1issueToken,2voteToken
+	OptionFeeDenoms      = "fee-denoms"       //comma separated coin types, empty for any
+	OptionMaxIssueLength = "max-issue-length" //number of bytes, 0 for no limit
+	OptionVotingPeriod   = "voting-period"    //number of blocks, 0 to never close
+
+	OptionMaxDescriptionLength = "max-description-length" //number of bytes, 0 for the most allowed
+)
+
+// P2VConfig holds the chain parameters of the plugin, set through SetOption
+type P2VConfig struct {
+	Beneficiary    []byte      //Receives the creation fees, and vote fees of issues without a beneficiary
+	CreateFee      types.Coins //Fee to create an issue, whatever the tx offers
+	FeeDenoms      []string    //Coin types which vote fees may be paid in
+	MaxIssueLength int         //Longest issue name allowed
+	VotingPeriod   uint64      //Blocks an issue without an end height is open for
+
+	MaxDescriptionLength int //Longest issue description allowed, below the most allowed by the plugin
+}
+
+// AllowsDenom is true if vote fees may be paid in denom
+func (c P2VConfig) AllowsDenom(denom string) bool {
+	if len(c.FeeDenoms) == 0 {
+		return true
+	}
+	for _, d := range c.FeeDenoms {
+		if d == denom {
+			return true
+		}
+	}
+	return false
+}
+
+// DescriptionLimit is the longest issue description allowed
+func (c P2VConfig) DescriptionLimit() int {
+	if c.MaxDescriptionLength > 0 && c.MaxDescriptionLength < maxDescriptionLength {
+		return c.MaxDescriptionLength
+	}
+	return maxDescriptionLength
+}
+
+func ConfigKey() []byte {
+	return []byte("P2VPlugin.Config")
+}
+
+func getConfig(store types.KVStore) (config P2VConfig, err error) {
+	configBytes := store.Get(ConfigKey())
+	if len(configBytes) > 0 {
+		err = wire.ReadBinaryBytes(configBytes, &config)
+	}
+	return
+}
+
+func (p2v *P2VPlugin) SetOption(store types.KVStore, key string, value string) (log string) {
+	store = p2v.prefix(store)
+	config, err := getConfig(store)
+	if err != nil {
+		return "Error decoding state: " + err.Error()
+	}
+
+	switch key {
+	case OptionBeneficiary:
+		addr, err := hex.DecodeString(value)
+		if err != nil || (len(addr) != 0 && len(addr) != 20) {
+			return cmn.Fmt("Invalid address: %v", value)
+		}
+		config.Beneficiary = addr
+	case OptionCreateFee:
+		fee, err := parseCoins(value)
+		if err != nil {
+			return err.Error()
+		}
+		config.CreateFee = fee
+	case OptionFeeDenoms:
+		config.FeeDenoms = nil
+		for _, denom := range strings.Split(value, ",") {
+			if denom = strings.TrimSpace(denom); len(denom) > 0 {
+				config.FeeDenoms = append(config.FeeDenoms, denom)
+			}
+		}
+	case OptionMaxIssueLength:
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return cmn.Fmt("Invalid length: %v", value)
+		}
+		config.MaxIssueLength = length
+	case OptionMaxDescriptionLength:
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > maxDescriptionLength {
+			return cmn.Fmt("Invalid length: %v", value)
+		}
+		config.MaxDescriptionLength = length
+	case OptionVotingPeriod:
+		period, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return cmn.Fmt("Invalid voting period: %v", value)
+		}
+		config.VotingPeriod = period
+	default:
+		return cmn.Fmt("Unknown key: %s", key)
+	}
+
+	store.Set(ConfigKey(), wire.BinaryBytes(config))
+	return cmn.Fmt("Set %v: %v", key, value)
+}
+
+var coinRegexp = regexp.MustCompile(`^([0-9]+)([a-zA-Z]+)$`)
+
+// parseCoins reads an option value of the form <amount><denom>,...
+// sorted by denom, empty for no coins
+func parseCoins(value string) (coins types.Coins, err error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	for _, str := range strings.Split(value, ",") {
+		matches := coinRegexp.FindStringSubmatch(strings.TrimSpace(str))
+		if matches == nil {
+			return nil, fmt.Errorf("Invalid coins, expected <amount><denom>,...: %s", value)
+		}
+		amount, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid amount: %s: %v", matches[1], err)
+		}
+		coins = append(coins, types.Coin{Denom: matches[2], Amount: amount})
+	}
+	if !coins.IsValid() {
+		return nil, fmt.Errorf("Invalid coins, must be positive and sorted by denom: %s", value)
+	}
+	return coins, nil
+}
@@ -0,0 +1,297 @@
+package paytovote
+
+import (
+	"bytes"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/state"
+	"github.com/tendermint/basecoin/types"
+	cmn "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+)
+
+// DelegateTx hands the voice of the caller to a delegate, whose ballot is
+// counted for the caller, at the vote fee, on every issue the caller does
+// not vote on directly. A delegation on one issue takes the place of the
+// one on all issues, and delegations are followed down the line until a
+// delegate who voted.
+type DelegateTx struct {
+	Issue    string //Issue to delegate on, empty for all issues
+	Delegate []byte //Address of the delegate, empty to revoke the delegation
+}
+
+func NewDelegateTxBytes(tx DelegateTx) []byte {
+	data := wire.BinaryBytes(tx)
+	data = append([]byte{TypeByteTxDelegate}, data...)
+	return data
+}
+
+// DelegateKey is where the delegate of a voter is stored, on one issue
+// or on all issues if the issue is empty
+func DelegateKey(issue string, delegator []byte) []byte {
+	if len(issue) == 0 {
+		return []byte(cmn.Fmt("P2VPlugin{delegator=%X}.Delegate", delegator))
+	}
+	return []byte(cmn.Fmt("P2VPlugin{issue=%v,delegator=%X}.Delegate", issue, delegator))
+}
+
+// DelegatorsKey is where the addresses of everyone who delegated to the
+// delegate are stored, on one issue or on all issues if the issue is empty
+func DelegatorsKey(issue string, delegate []byte) []byte {
+	if len(issue) == 0 {
+		return []byte(cmn.Fmt("P2VPlugin{delegate=%X}.Delegators", delegate))
+	}
+	return []byte(cmn.Fmt("P2VPlugin{issue=%v,delegate=%X}.Delegators", issue, delegate))
+}
+
+func getDelegate(store types.KVStore, issue string, delegator []byte) (delegate []byte, err error) {
+	delegateBytes := store.Get(DelegateKey(issue, delegator))
+	if len(delegateBytes) > 0 {
+		err = wire.ReadBinaryBytes(delegateBytes, &delegate)
+	}
+	return
+}
+
+func getDelegators(store types.KVStore, issue string, delegate []byte) (delegators [][]byte, err error) {
+	delegatorsBytes := store.Get(DelegatorsKey(issue, delegate))
+	if len(delegatorsBytes) > 0 {
+		err = wire.ReadBinaryBytes(delegatorsBytes, &delegators)
+	}
+	return
+}
+
+func (p2v *P2VPlugin) runTxDelegate(store, accts types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+
+	// Decode tx
+	var tx DelegateTx
+	err := wire.ReadBinaryBytes(txBytes, &tx)
+	if err != nil {
+		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
+	}
+
+	//Validate Tx
+	switch {
+	case len(tx.Delegate) != 0 && len(tx.Delegate) != 20:
+		return abci.ErrInternalError.AppendLog("P2VTx.Delegate must be a 20 byte address")
+	case bytes.Equal(tx.Delegate, ctx.CallerAddress):
+		return abci.ErrInternalError.AppendLog("P2VTx.Delegate cannot be the caller")
+	}
+	if len(tx.Issue) > 0 {
+		p2vIssue, err := getIssue(store, tx.Issue)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
+		}
+		if p2vIssue.Status != StatusOpen {
+			return abci.ErrInternalError.AppendLog("Issue has already closed")
+		}
+	}
+
+	// Keep the delegators listed under their delegate, so they can be
+	// found from the voters when issues close
+	previous, err := getDelegate(store, tx.Issue, ctx.CallerAddress)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	if len(previous) > 0 {
+		delegators, err := getDelegators(store, tx.Issue, previous)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+		}
+		if i := findAddress(delegators, ctx.CallerAddress); i >= 0 {
+			delegators = append(delegators[:i:i], delegators[i+1:]...)
+		}
+		store.Set(DelegatorsKey(tx.Issue, previous), wire.BinaryBytes(delegators))
+	}
+	if len(tx.Delegate) > 0 {
+		delegators, err := getDelegators(store, tx.Issue, tx.Delegate)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+		}
+		if findAddress(delegators, ctx.CallerAddress) < 0 {
+			delegators = append(delegators, ctx.CallerAddress)
+		}
+		store.Set(DelegatorsKey(tx.Issue, tx.Delegate), wire.BinaryBytes(delegators))
+	}
+
+	// Save the delegation, delegating is free but every vote it casts is not
+	store.Set(DelegateKey(tx.Issue, ctx.CallerAddress), wire.BinaryBytes(tx.Delegate))
+	chargeFee(accts, ctx, nil, nil)
+	return abci.OK
+}
+
+// delegation is who the voter delegated to on the issue, if anyone
+func delegation(store types.KVStore, issue string, delegator []byte) ([]byte, error) {
+	delegate, err := getDelegate(store, issue, delegator)
+	if err != nil || len(delegate) > 0 {
+		return delegate, err
+	}
+	return getDelegate(store, "", delegator)
+}
+
+// leaning is the side or choice a voter put the most votes on, the
+// earliest on a tie, which is their whole ballot on a one ballot issue
+func (v P2VVoter) leaning() (voteTypeByte byte, choice string) {
+	most := 0
+	if v.VotesFor > most {
+		voteTypeByte, most = TypeByteVoteFor, v.VotesFor
+	}
+	if v.VotesAgainst > most {
+		voteTypeByte, most = TypeByteVoteAgainst, v.VotesAgainst
+	}
+	for _, c := range v.Choices {
+		if c.Votes > most {
+			voteTypeByte, choice, most = TypeByteVoteChoice, c.Name, c.Votes
+		}
+	}
+	return
+}
+
+// delegatedVote is a delegator found to follow the ballot of a voter
+type delegatedVote struct {
+	delegator    []byte
+	voteTypeByte byte
+	choice       string
+	ranking      []string
+}
+
+// countDelegations counts one vote for everyone who delegated their voice
+// on the issue and did not vote directly, on the side of their delegate.
+// The delegators are found by following the delegations back from the
+// voters of the issue, so only delegations which end at a ballot are
+// looked at. Each delegator pays the vote fee for the vote, and is not
+// counted if they cannot. On a weighted issue the vote weighs what the
+// delegator holds of the weight denom after the fee, which is paid with
+// the fee like the weight of a direct vote, and delegators without any
+// are not counted. Counted delegators are recorded as voters.
+// Returns the ballots the delegators add to a ranked issue.
+func countDelegations(store, accts types.KVStore, p2vIssue *P2VIssue) ([]Ranking, error) {
+	config, err := getConfig(store)
+	if err != nil {
+		return nil, err
+	}
+	voters, err := getVoters(store, p2vIssue.Issue)
+	if err != nil {
+		return nil, err
+	}
+
+	// read everything before charging anyone, so a failure charges no one
+	found, err := findDelegations(store, p2vIssue, voters)
+	if err != nil {
+		return nil, err
+	}
+
+	var rankings []Ranking
+	fee, payee := p2vIssue.FeePerVote, p2vIssue.voteFeePayee(config)
+	for _, d := range found {
+		acc := state.GetAccount(accts, d.delegator)
+		if acc == nil || !acc.Balance.IsGTE(fee) {
+			continue
+		}
+		var weight int64
+		charge := fee
+		if p2vIssue.Mode == ModeWeighted {
+			denom := p2vIssue.WeightDenom()
+			weight = amountOf(acc.Balance.Minus(fee), denom)
+			if weight <= 0 {
+				continue
+			}
+			charge = fee.Plus(types.Coins{{denom, weight}})
+		}
+		if !charge.IsZero() {
+			acc.Balance = acc.Balance.Minus(charge)
+			state.SetAccount(accts, d.delegator, acc)
+			if len(payee) > 0 {
+				pay(accts, payee, charge)
+			}
+		}
+
+		if p2vIssue.Ranked {
+			rankings = append(rankings, Ranking{d.delegator, d.ranking, 1, weight})
+		}
+		p2vIssue.addVotes(d.voteTypeByte, d.choice, 1, weight)
+		p2vIssue.FeesCollected = p2vIssue.FeesCollected.Plus(charge)
+		p2vIssue.Delegated += 1
+
+		var p2vVoter P2VVoter
+		p2vVoter.addVotes(d.voteTypeByte, d.choice, 1, weight)
+		p2vVoter.Paid = charge
+		store.Set(VoterKey(p2vIssue.Issue, d.delegator), wire.BinaryBytes(p2vVoter))
+		voters = append(voters, d.delegator)
+	}
+	store.Set(VotersKey(p2vIssue.Issue), wire.BinaryBytes(voters))
+	return rankings, nil
+}
+
+// findDelegations walks back from every voter with a ballot on the issue
+// to everyone whose delegations lead to them
+func findDelegations(store types.KVStore, p2vIssue *P2VIssue, voters [][]byte) ([]delegatedVote, error) {
+	issue := p2vIssue.Issue
+
+	// everyone who voted directly is counted on their own
+	counted := map[string]bool{}
+	for _, voter := range voters {
+		counted[string(voter)] = true
+	}
+
+	var found []delegatedVote
+	for _, voter := range voters {
+		p2vVoter, err := getVoter(store, issue, voter)
+		if err != nil {
+			return nil, err
+		}
+		vote := delegatedVote{}
+		vote.voteTypeByte, vote.choice = p2vVoter.leaning()
+		if vote.voteTypeByte == 0 {
+			continue
+		}
+		if p2vIssue.Ranked {
+			ranking, err := getRanking(store, issue, p2vVoter.Ranking-1)
+			if err != nil {
+				return nil, err
+			}
+			if len(ranking.Ranking) == 0 {
+				continue
+			}
+			vote.choice, vote.ranking = ranking.Ranking[0], ranking.Ranking
+		}
+
+		for queue := [][]byte{voter}; len(queue) > 0; queue = queue[1:] {
+			delegate := queue[0]
+			onIssue, err := getDelegators(store, issue, delegate)
+			if err != nil {
+				return nil, err
+			}
+			onAll, err := getDelegators(store, "", delegate)
+			if err != nil {
+				return nil, err
+			}
+			for _, delegator := range append(onIssue, onAll...) {
+				if counted[string(delegator)] {
+					continue
+				}
+				// skip delegations on all issues replaced by one on this issue
+				current, err := delegation(store, issue, delegator)
+				if err != nil {
+					return nil, err
+				}
+				if !bytes.Equal(current, delegate) {
+					continue
+				}
+				counted[string(delegator)] = true
+				vote.delegator = delegator
+				found = append(found, vote)
+				queue = append(queue, delegator)
+			}
+		}
+	}
+	return found, nil
+}
+
+func findAddress(addrs [][]byte, addr []byte) int {
+	for i, a := range addrs {
+		if bytes.Equal(a, addr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,6 +1,11 @@
 package paytovote
 
 import (
+	"crypto/sha256"
+	"errors"
+	"math"
+	"math/big"
+
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin/state"
 	"github.com/tendermint/basecoin/types"
@@ -8,54 +13,146 @@ import (
 	"github.com/tendermint/go-wire"
 )
 
+// P2VPlugin is a plugin, storing all state prefixed with its unique name
 type P2VPlugin struct {
-	name string
+	name   string
+	height uint64
 }
 
-func New() *P2VPlugin {
+func New(name string) *P2VPlugin {
 	return &P2VPlugin{
-		name: "paytovote",
+		name: name,
 	}
 }
 
 ///////////////////////////////////////////////////
 
 const (
-	TypeByteTxCreate byte = 0x01
-	TypeByteTxVote   byte = 0x02
+	TypeByteTxCreate   byte = 0x01
+	TypeByteTxVote     byte = 0x02
+	TypeByteTxReveal   byte = 0x03
+	TypeByteTxDelegate byte = 0x04
 
 	TypeByteVoteFor     byte = 0x01
 	TypeByteVoteAgainst byte = 0x02
+	TypeByteVoteChoice  byte = 0x03 //Vote for a named choice of a multiple choice issue
+
+	StatusOpen     byte = 0x00
+	StatusPassed   byte = 0x01
+	StatusRejected byte = 0x02
+	StatusNoQuorum byte = 0x03
+
+	ModeCount     byte = 0x00 //Every vote counts once
+	ModeWeighted  byte = 0x01 //Votes are weighted by the coins paid above the fee
+	ModeQuadratic byte = 0x02 //A voter casting N votes in total pays N^2 times the fee
+
+	SettleNone          byte = 0x00 //Vote fees are paid out as they come in
+	SettleRefundLosers  byte = 0x01 //Vote fees are held, and refunded to the losing side on close
+	SettleRefundWinners byte = 0x02 //Vote fees are held, and refunded to the winning side on close
+
+	maxQuadraticVotes = 1 << 20 //Most votes one voter may buy on a quadratic issue
+	maxChoices        = 64      //Most choices a multiple choice issue may have
+
+	maxDescriptionLength = 4096 //Longest description of an issue, unless the chain allows less
+	maxLinkLength        = 256  //Longest link to where an issue is proposed
+	maxContentHashLength = 64   //Longest hash of the proposal document
+
+	//AbstainChoice counts towards the quorum but never wins
+	AbstainChoice = "abstain"
 )
 
-type createIssueTx struct {
+type CreateIssueTx struct {
 	Issue           string      //Issue to be created
 	FeePerVote      types.Coins //Cost to vote for the issue
 	Fee2CreateIssue types.Coins //Cost to create a new issue
+	EndHeight       uint64      //Last block height to accept votes, 0 to never close
+	Quorum          int         //Minimum number of votes for the outcome to count
+	Threshold       Threshold   //Share of the votes needed to pass
+	Mode            byte        //How votes are counted
+	WeightDenom     string      //Coin type which weighs votes in ModeWeighted
+	OneBallot       bool        //Each address casts one ballot, which it may change
+	Choices         []string    //Names of the choices of a multiple choice issue
+	Ranked          bool        //Voters rank the choices, decided by instant-runoff
+	Beneficiary     []byte      //Receives the vote fees, instead of the plugin beneficiary
+	Settlement      byte        //When and to whom vote fees are paid, needs OneBallot if held
+	RevealHeight    uint64      //Last block height to reveal secret ballots, 0 for open ballots
+	Description     string      //What the issue proposes
+	Link            string      //Where the proposal can be read, such as a URL
+	ContentHash     []byte      //Hash of an off-chain proposal document, if any
+}
+
+// Threshold is the share of votes needed for an issue to pass,
+// for example {2, 3} for a two-thirds majority. The zero value
+// is a simple majority: more votes for than against. On multiple
+// choice issues it is the share the leading choice needs, and the
+// zero value is a plurality.
+type Threshold struct {
+	Num int
+	Den int
+}
+
+func (t Threshold) IsZero() bool {
+	return t.Num == 0 && t.Den == 0
+}
+
+func (t Threshold) IsValid() bool {
+	return t.IsZero() || (t.Num > 0 && t.Den > 0 && t.Num <= t.Den)
+}
+
+// Passes is true if votesFor out of all votes reaches the threshold.
+// Weighted tallies can be large, so the products are taken as big.Int.
+func (t Threshold) Passes(votesFor, votes int64) bool {
+	if votesFor == 0 {
+		return false
+	}
+	num, den := int64(t.Num), int64(t.Den)
+	if t.IsZero() {
+		num, den = 1, 2
+	}
+	lhs := new(big.Int).Mul(big.NewInt(votesFor), big.NewInt(den))
+	rhs := new(big.Int).Mul(big.NewInt(votes), big.NewInt(num))
+	if t.IsZero() {
+		return lhs.Cmp(rhs) > 0
+	}
+	return lhs.Cmp(rhs) >= 0
 }
 
-type voteTx struct {
-	Issue        string //Issue being voted for
-	VoteTypeByte byte   //How is the vote being cast
+type VoteTx struct {
+	Issue        string   //Issue being voted for
+	VoteTypeByte byte     //How is the vote being cast
+	Votes        int      //Number of votes to cast in ModeQuadratic, 0 for one
+	Choice       string   //Choice being voted for with TypeByteVoteChoice
+	Ranking      []string //Choices in order of preference on a ranked issue
+	Commitment   []byte   //RevealTx.Commitment of the ballot on a secret issue, instead of the ballot
 }
 
 func NewCreateIssueTxBytes(issue string, feePerVote, fee2CreateIssue types.Coins) []byte {
-	data := wire.BinaryBytes(
-		createIssueTx{
+	return NewCreateIssueTxBytesFrom(
+		CreateIssueTx{
 			Issue:           issue,
 			FeePerVote:      feePerVote,
 			Fee2CreateIssue: fee2CreateIssue,
 		})
-	data = append([]byte{TypeByteTxCreate}, data...)
-	return data
 }
 
 func NewVoteTxBytes(issue string, voteTypeByte byte) []byte {
-	data := wire.BinaryBytes(
-		voteTx{
+	return NewVoteTxBytesFrom(
+		VoteTx{
 			Issue:        issue,
 			VoteTypeByte: voteTypeByte,
 		})
+}
+
+// NewCreateIssueTxBytesFrom encodes a CreateIssueTx with any of its options
+func NewCreateIssueTxBytesFrom(tx CreateIssueTx) []byte {
+	data := wire.BinaryBytes(tx)
+	data = append([]byte{TypeByteTxCreate}, data...)
+	return data
+}
+
+// NewVoteTxBytesFrom encodes a VoteTx with any of its options
+func NewVoteTxBytesFrom(tx VoteTx) []byte {
+	data := wire.BinaryBytes(tx)
 	data = append([]byte{TypeByteTxVote}, data...)
 	return data
 }
@@ -67,32 +164,358 @@ type P2VIssue struct {
 	FeePerVote   types.Coins
 	VotesFor     int
 	VotesAgainst int
+	EndHeight    uint64    //Last block height to accept votes, 0 to never close
+	Quorum       int       //Minimum number of votes for the outcome to count
+	Threshold    Threshold //Share of the votes needed to pass
+	Status       byte      //StatusOpen until closed, then the outcome
+
+	Mode          byte       //How votes are counted
+	WeightFor     types.Coin //Total weight of the votes for, in ModeWeighted
+	WeightAgainst types.Coin //Total weight of the votes against, in ModeWeighted
+	OneBallot     bool       //Each address casts one ballot, which it may change
+
+	Choices []Choice //Tally of each choice of a multiple choice issue
+	Winner  string   //Choice which passed, if any
+
+	Ranked   bool    //Choices are ranked, Choices holds first preferences
+	Rankings int     //Number of ballots stored under RankingKey
+	Rounds   []Round //Counts of the instant-runoff once closed
+
+	Beneficiary   []byte      //Receives the vote fees, instead of the plugin beneficiary
+	FeesCollected types.Coins //Total of the vote fees paid
+	Settlement    byte        //When and to whom vote fees are paid
+	Refunded      types.Coins //Held vote fees paid back to voters on close
+
+	RevealHeight uint64      //Last block height to reveal secret ballots, 0 for open ballots
+	Unrevealed   int         //Number of secret ballots never revealed
+	Forfeited    types.Coins //Vote fees paid for secret ballots never revealed
+
+	Delegated int //Number of voters whose delegate's ballot was counted for them
+
+	Proposer      []byte //Address which created the issue
+	CreatedHeight uint64 //Block height the issue was created at
+	Description   string //What the issue proposes
+	Link          string //Where the proposal can be read, such as a URL
+	ContentHash   []byte //Hash of an off-chain proposal document, if any
+}
+
+// legacyP2VIssue is how issues were stored before any options were added.
+// Such issues are open for good, with one vote per fee and a simple majority.
+type legacyP2VIssue struct {
+	Issue        string
+	FeePerVote   types.Coins
+	VotesFor     int
+	VotesAgainst int
+}
+
+// Choice is one option of a multiple choice issue and its tally
+type Choice struct {
+	Name   string
+	Votes  int
+	Weight int64 //Total weight of the votes in ModeWeighted
+}
+
+func (c Choice) tally(weighted bool) int64 {
+	if weighted {
+		return c.Weight
+	}
+	return int64(c.Votes)
+}
+
+func newChoices(names []string) []Choice {
+	choices := make([]Choice, len(names))
+	for i, name := range names {
+		choices[i].Name = name
+	}
+	return choices
+}
+
+func findChoice(choices []Choice, name string) int {
+	for i, choice := range choices {
+		if choice.Name == name {
+			return i
+		}
+	}
+	return -1
 }
 
-func newP2VIssue(issue string, feePerVote types.Coins) P2VIssue {
+// validChoices requires at least two distinct, named choices besides abstaining
+func validChoices(names []string) bool {
+	if len(names) > maxChoices {
+		return false
+	}
+	seen, options := map[string]bool{}, 0
+	for _, name := range names {
+		if len(name) == 0 || seen[name] {
+			return false
+		}
+		seen[name] = true
+		if name != AbstainChoice {
+			options++
+		}
+	}
+	return options >= 2
+}
+
+func newP2VIssue(tx CreateIssueTx) P2VIssue {
 	return P2VIssue{
-		Issue:        issue,
-		FeePerVote:   feePerVote,
+		Issue:        tx.Issue,
+		FeePerVote:   tx.FeePerVote,
 		VotesFor:     0,
 		VotesAgainst: 0,
+		EndHeight:    tx.EndHeight,
+		Quorum:       tx.Quorum,
+		Threshold:    tx.Threshold,
+		Status:       StatusOpen,
+
+		Mode:          tx.Mode,
+		WeightFor:     types.Coin{Denom: tx.WeightDenom},
+		WeightAgainst: types.Coin{Denom: tx.WeightDenom},
+		OneBallot:     tx.OneBallot,
+
+		Choices: newChoices(tx.Choices),
+		Ranked:  tx.Ranked,
+
+		Beneficiary: tx.Beneficiary,
+		Settlement:  tx.Settlement,
+
+		RevealHeight: tx.RevealHeight,
+
+		Description: tx.Description,
+		Link:        tx.Link,
+		ContentHash: tx.ContentHash,
+	}
+}
+
+// FeeBeneficiary is who receives the vote fees, none if they are burned
+func (p P2VIssue) FeeBeneficiary(config P2VConfig) []byte {
+	if len(p.Beneficiary) > 0 {
+		return p.Beneficiary
+	}
+	return config.Beneficiary
+}
+
+func (p P2VIssue) WeightDenom() string {
+	return p.WeightFor.Denom
+}
+
+// addVotes counts votes of the given weight towards one side, or choice,
+// returning false if that is not something to vote for on this issue
+func (p *P2VIssue) addVotes(voteTypeByte byte, choice string, votes int, weight int64) bool {
+	if (voteTypeByte == TypeByteVoteChoice) != (len(p.Choices) > 0) {
+		return false
+	}
+	switch voteTypeByte {
+	case TypeByteVoteFor:
+		p.VotesFor += votes
+		p.WeightFor.Amount += weight
+	case TypeByteVoteAgainst:
+		p.VotesAgainst += votes
+		p.WeightAgainst.Amount += weight
+	case TypeByteVoteChoice:
+		i := findChoice(p.Choices, choice)
+		if i < 0 {
+			return false
+		}
+		p.Choices[i].Votes += votes
+		p.Choices[i].Weight += weight
+	default:
+		return false
+	}
+	return true
+}
+
+// VotesCast is the number of votes on all sides, including abstentions
+func (p P2VIssue) VotesCast() int {
+	votes := p.VotesFor + p.VotesAgainst
+	for _, choice := range p.Choices {
+		votes += choice.Votes
+	}
+	return votes
+}
+
+// IsClosed is true once no more votes are accepted at the given height
+func (p P2VIssue) IsClosed(height uint64) bool {
+	return p.Status != StatusOpen || (p.EndHeight != 0 && height > p.EndHeight)
+}
+
+// Tally is the weight of the votes for and of all votes cast
+func (p P2VIssue) Tally() (votesFor, votes int64) {
+	if p.Mode == ModeWeighted {
+		return p.WeightFor.Amount, p.WeightFor.Amount + p.WeightAgainst.Amount
+	}
+	return int64(p.VotesFor), int64(p.VotesFor + p.VotesAgainst)
+}
+
+// leader is the choice with the largest tally, if there is no tie for it,
+// along with the tallies of the leader and of all choices but abstaining
+func (p P2VIssue) leader() (name string, leading, votes int64) {
+	tie := false
+	for _, choice := range p.Choices {
+		if choice.Name == AbstainChoice {
+			continue
+		}
+		tally := choice.tally(p.Mode == ModeWeighted)
+		votes += tally
+		switch {
+		case tally > leading:
+			name, leading, tie = choice.Name, tally, false
+		case tally == leading:
+			tie = true
+		}
+	}
+	if tie {
+		return "", leading, votes
+	}
+	return name, leading, votes
+}
+
+// close records the final outcome of the vote
+func (p *P2VIssue) close() {
+	if p.VotesCast() < p.Quorum {
+		p.Status = StatusNoQuorum
+		return
+	}
+
+	if len(p.Choices) > 0 {
+		p.elect(p.leader())
+		return
+	}
+
+	votesFor, votes := p.Tally()
+	switch {
+	case p.Threshold.Passes(votesFor, votes):
+		p.Status = StatusPassed
+	default:
+		p.Status = StatusRejected
+	}
+}
+
+// elect passes the issue with the named choice as the winner, if it
+// reaches the threshold with leading out of all votes
+func (p *P2VIssue) elect(name string, leading, votes int64) {
+	if len(name) > 0 && (p.Threshold.IsZero() || p.Threshold.Passes(leading, votes)) {
+		p.Status, p.Winner = StatusPassed, name
+	} else {
+		p.Status = StatusRejected
 	}
 }
 
 func IssueKey(issue string) []byte {
-	//The state key is defined as only being affected by effected issue
-	// aka. if multiple paytovote plugins are initialized
-	// then all will have access to the same issue vote counts
+	//The state key is only affected by the issue, the plugin
+	// keeps it apart from other paytovote plugins by its name
 	return []byte(cmn.Fmt("P2VPlugin{issue=%v}.State", issue))
 }
 
+// IssuesKey is where the names of all issues are listed, in the order
+// they were created
+func IssuesKey() []byte {
+	return []byte("P2VPlugin.Issues")
+}
+
+// ClosingKey is where the names of all issues closing at height are stored
+func ClosingKey(height uint64) []byte {
+	return []byte(cmn.Fmt("P2VPlugin{endHeight=%v}.Issues", height))
+}
+
+// VoterKey is where what a voter has done on an issue is stored
+func VoterKey(issue string, voter []byte) []byte {
+	return []byte(cmn.Fmt("P2VPlugin{issue=%v,voter=%X}.State", issue, voter))
+}
+
+// P2VVoter is the ballot of one address on an issue: all the votes it has
+// cast on each side. The total sets the price of more in ModeQuadratic.
+type P2VVoter struct {
+	VotesFor      int
+	VotesAgainst  int
+	WeightFor     int64
+	WeightAgainst int64
+	Choices       []Choice    //Votes on each choice of a multiple choice issue
+	Ranking       int         //RankingKey index+1 of the latest ballot on a ranked issue
+	Paid          types.Coins //Vote fees paid on the issue
+
+	Commitment   []byte //Hash of the secret ballot
+	SealedVotes  int    //Votes paid for by the secret ballot, counted once revealed
+	SealedWeight int64  //Weight paid for by the secret ballot, counted once revealed
+	Revealed     bool
+}
+
+func (v P2VVoter) Votes() int {
+	votes := v.VotesFor + v.VotesAgainst
+	for _, choice := range v.Choices {
+		votes += choice.Votes
+	}
+	return votes
+}
+
+func (v *P2VVoter) addVotes(voteTypeByte byte, choice string, votes int, weight int64) {
+	switch voteTypeByte {
+	case TypeByteVoteFor:
+		v.VotesFor += votes
+		v.WeightFor += weight
+	case TypeByteVoteAgainst:
+		v.VotesAgainst += votes
+		v.WeightAgainst += weight
+	case TypeByteVoteChoice:
+		i := findChoice(v.Choices, choice)
+		if i < 0 {
+			v.Choices = append(v.Choices, Choice{Name: choice})
+			i = len(v.Choices) - 1
+		}
+		v.Choices[i].Votes += votes
+		v.Choices[i].Weight += weight
+	}
+}
+
+// ballot is the side or choice a voter is on with all its votes
+// and weight, which is only meaningful for one ballot issues
+func (v P2VVoter) ballot() (voteTypeByte byte, choice string, votes int, weight int64) {
+	switch {
+	case v.VotesFor > 0:
+		return TypeByteVoteFor, "", v.VotesFor, v.WeightFor
+	case v.VotesAgainst > 0:
+		return TypeByteVoteAgainst, "", v.VotesAgainst, v.WeightAgainst
+	}
+	for _, c := range v.Choices {
+		if c.Votes > 0 {
+			return TypeByteVoteChoice, c.Name, c.Votes, c.Weight
+		}
+	}
+	return
+}
+
+func getVoter(store types.KVStore, issue string, voter []byte) (p2vVoter P2VVoter, err error) {
+	voterBytes := store.Get(VoterKey(issue, voter))
+	if len(voterBytes) > 0 {
+		err = wire.ReadBinaryBytes(voterBytes, &p2vVoter)
+	}
+	return
+}
+
+func getClosing(store types.KVStore, height uint64) (issues []string, err error) {
+	issuesBytes := store.Get(ClosingKey(height))
+	if len(issuesBytes) > 0 {
+		err = wire.ReadBinaryBytes(issuesBytes, &issues)
+	}
+	return
+}
+
+func getIssues(store types.KVStore) (issues []string, err error) {
+	issuesBytes := store.Get(IssuesKey())
+	if len(issuesBytes) > 0 {
+		err = wire.ReadBinaryBytes(issuesBytes, &issues)
+	}
+	return
+}
+
 func getIssue(store types.KVStore, issue string) (p2vIssue P2VIssue, err error) {
 	p2vIssueBytes := store.Get(IssueKey(issue))
 
 	//Determine if the issue already exists and load
 	if len(p2vIssueBytes) > 0 { //is there a record of the issue existing?
-		err = wire.ReadBinaryBytes(p2vIssueBytes, &p2vIssue)
+		p2vIssue, err = DecodeIssue(p2vIssueBytes)
 		if err != nil {
-			err = abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+			return p2vIssue, abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
 		}
 	} else {
 		err = abci.ErrInternalError.AppendLog("Tx Issue not found")
@@ -100,17 +523,33 @@ func getIssue(store types.KVStore, issue string) (p2vIssue P2VIssue, err error)
 	return
 }
 
+// DecodeIssue reads an issue as stored under IssueKey, falling back
+// to the format from before the issue options
+func DecodeIssue(p2vIssueBytes []byte) (p2vIssue P2VIssue, err error) {
+	err = wire.ReadBinaryBytes(p2vIssueBytes, &p2vIssue)
+	if err == nil {
+		return p2vIssue, nil
+	}
+	var legacy legacyP2VIssue
+	if wire.ReadBinaryBytes(p2vIssueBytes, &legacy) != nil {
+		return P2VIssue{}, err
+	}
+	return P2VIssue{
+		Issue:        legacy.Issue,
+		FeePerVote:   legacy.FeePerVote,
+		VotesFor:     legacy.VotesFor,
+		VotesAgainst: legacy.VotesAgainst,
+	}, nil
+}
+
 ///////////////////////////////////////////////////
 
 func (p2v *P2VPlugin) Name() string {
 	return p2v.name
 }
 
-func (p2v *P2VPlugin) SetOption(store types.KVStore, key string, value string) (log string) {
-	return ""
-}
-
 func (p2v *P2VPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+	pstore := p2v.prefix(store)
 
 	defer func() {
 		//Return the ctx coins to the wallet if there is an error
@@ -129,15 +568,21 @@ func (p2v *P2VPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes
 	//Note that the zero position of txBytes contains the type-byte for the tx type
 	switch txBytes[0] {
 	case TypeByteTxCreate:
-		return p2v.runTxCreateIssue(store, ctx, txBytes[1:])
+		return p2v.runTxCreateIssue(pstore, store, ctx, txBytes[1:])
 	case TypeByteTxVote:
-		return p2v.runTxVote(store, ctx, txBytes[1:])
+		return p2v.runTxVote(pstore, store, ctx, txBytes[1:])
+	case TypeByteTxReveal:
+		return p2v.runTxReveal(pstore, store, ctx, txBytes[1:])
+	case TypeByteTxDelegate:
+		return p2v.runTxDelegate(pstore, store, ctx, txBytes[1:])
 	default:
 		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: bad prepended bytes")
 	}
 }
 
-func chargeFee(store types.KVStore, ctx types.CallContext, fee types.Coins) {
+// chargeFee keeps the fee out of the context coins, paying it to the
+// beneficiary if there is one, and returns the rest to the caller
+func chargeFee(accts types.KVStore, ctx types.CallContext, fee types.Coins, beneficiary []byte) {
 
 	//Charge the Fee from the context coins
 	leftoverCoins := ctx.Coins.Minus(fee)
@@ -145,14 +590,29 @@ func chargeFee(store types.KVStore, ctx types.CallContext, fee types.Coins) {
 		acc := ctx.CallerAccount
 		//return leftover coins
 		acc.Balance = acc.Balance.Plus(leftoverCoins)   // subtract fees
-		state.SetAccount(store, ctx.CallerAddress, acc) // save the new balance
+		state.SetAccount(accts, ctx.CallerAddress, acc) // save the new balance
+	}
+
+	//Pay the fee onwards, loading the account after the caller's was saved
+	if len(beneficiary) > 0 && !fee.IsZero() {
+		pay(accts, beneficiary, fee)
+	}
+}
+
+// pay adds coins to the account at addr, creating it if needed
+func pay(accts types.KVStore, addr []byte, coins types.Coins) {
+	acc := state.GetAccount(accts, addr)
+	if acc == nil {
+		acc = &types.Account{}
 	}
+	acc.Balance = acc.Balance.Plus(coins)
+	state.SetAccount(accts, addr, acc)
 }
 
-func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+func (p2v *P2VPlugin) runTxCreateIssue(store, accts types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
 
 	// Decode tx
-	var tx createIssueTx
+	var tx CreateIssueTx
 	err := wire.ReadBinaryBytes(txBytes, &tx)
 	if err != nil {
 		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
@@ -172,6 +632,74 @@ func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContex
 		return abci.ErrInternalError.AppendLog("P2VTx.Fee2CreateIssue must be nonnegative")
 	case !ctx.Coins.IsGTE(tx.Fee2CreateIssue): // Did the caller provide enough coins?
 		return abci.ErrInsufficientFunds.AppendLog("Tx Funds insufficient for creating a new issue")
+	case tx.Quorum < 0:
+		return abci.ErrInternalError.AppendLog("P2VTx.Quorum must be nonnegative")
+	case !tx.Threshold.IsValid():
+		return abci.ErrInternalError.AppendLog("P2VTx.Threshold must be a fraction between 0 and 1")
+	case tx.Mode != ModeCount && tx.Mode != ModeWeighted && tx.Mode != ModeQuadratic:
+		return abci.ErrInternalError.AppendLog("P2VTx.Mode was not recognized")
+	case tx.Mode == ModeWeighted && tx.WeightDenom == "":
+		return abci.ErrInternalError.AppendLog("P2VTx.WeightDenom must be given to weigh the votes")
+	case tx.Mode != ModeWeighted && tx.WeightDenom != "":
+		return abci.ErrInternalError.AppendLog("P2VTx.WeightDenom is only used on weighted issues")
+	case len(tx.Choices) > 0 && !validChoices(tx.Choices):
+		return abci.ErrInternalError.AppendLog("P2VTx.Choices must be at least two distinct names besides abstain")
+	case tx.Ranked && len(tx.Choices) == 0:
+		return abci.ErrInternalError.AppendLog("P2VTx.Choices must be given to rank them")
+	case tx.EndHeight != 0 && tx.EndHeight < p2v.height:
+		return abci.ErrInternalError.AppendLog("P2VTx.EndHeight has already passed")
+	case len(tx.Beneficiary) != 0 && len(tx.Beneficiary) != 20:
+		return abci.ErrInternalError.AppendLog("P2VTx.Beneficiary must be an address")
+	case tx.Settlement > SettleRefundWinners:
+		return abci.ErrInternalError.AppendLog("P2VTx.Settlement was not recognized")
+	case tx.Settlement != SettleNone && !tx.OneBallot && tx.RevealHeight == 0:
+		return abci.ErrInternalError.AppendLog("P2VTx.OneBallot is needed to hold the vote fees")
+	case len(tx.Link) > maxLinkLength:
+		return abci.ErrInternalError.AppendLog(cmn.Fmt("P2VTx.Link must be at most %v bytes", maxLinkLength))
+	case len(tx.ContentHash) > maxContentHashLength:
+		return abci.ErrInternalError.AppendLog(cmn.Fmt("P2VTx.ContentHash must be at most %v bytes", maxContentHashLength))
+	case tx.Mode == ModeQuadratic && !canScale(tx.FeePerVote, maxQuadraticVotes*maxQuadraticVotes):
+		return abci.ErrInternalError.AppendLog("P2VTx.FeePerVote is too high to price the most quadratic votes")
+	}
+
+	// Enforce the chain parameters
+	config, err := getConfig(store)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	if config.MaxIssueLength > 0 && len(tx.Issue) > config.MaxIssueLength {
+		return abci.ErrInternalError.AppendLog(cmn.Fmt("P2VTx.Issue must be at most %v bytes", config.MaxIssueLength))
+	}
+	if maxLength := config.DescriptionLimit(); len(tx.Description) > maxLength {
+		return abci.ErrInternalError.AppendLog(cmn.Fmt("P2VTx.Description must be at most %v bytes", maxLength))
+	}
+	for _, coin := range tx.FeePerVote {
+		if !config.AllowsDenom(coin.Denom) {
+			return abci.ErrInternalError.AppendLog("P2VTx.FeePerVote cannot be paid in " + coin.Denom)
+		}
+	}
+	if tx.Mode == ModeWeighted && !config.AllowsDenom(tx.WeightDenom) {
+		return abci.ErrInternalError.AppendLog("P2VTx.WeightDenom cannot be paid in " + tx.WeightDenom)
+	}
+	fee2CreateIssue := tx.Fee2CreateIssue
+	if !config.CreateFee.IsZero() {
+		if !tx.Fee2CreateIssue.IsGTE(config.CreateFee) {
+			return abci.ErrInsufficientFunds.AppendLog("P2VTx.Fee2CreateIssue must cover the fee of " + config.CreateFee.String())
+		}
+		fee2CreateIssue = config.CreateFee
+	} else {
+		// the fee offered is charged, so it is held to the fee denoms too
+		for _, coin := range tx.Fee2CreateIssue {
+			if !config.AllowsDenom(coin.Denom) {
+				return abci.ErrInternalError.AppendLog("P2VTx.Fee2CreateIssue cannot be paid in " + coin.Denom)
+			}
+		}
+	}
+	if tx.EndHeight == 0 && config.VotingPeriod > 0 {
+		tx.EndHeight = p2v.height + config.VotingPeriod
+	}
+	if tx.RevealHeight != 0 && (tx.EndHeight == 0 || tx.RevealHeight <= tx.EndHeight) {
+		return abci.ErrInternalError.AppendLog("P2VTx.RevealHeight must be after the EndHeight")
 	}
 
 	//Return if the issue already exists, aka no error was thrown
@@ -179,17 +707,34 @@ func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContex
 		return abci.ErrInternalError.AppendLog("Cannot create an already existing issue")
 	}
 
-	// Create and Save P2VIssue, charge fee, return
-	newP2VIssue := newP2VIssue(tx.Issue, tx.FeePerVote)
+	// Remember to close the issue at its end height
+	newP2VIssue := newP2VIssue(tx)
+	newP2VIssue.Proposer = ctx.CallerAddress
+	newP2VIssue.CreatedHeight = p2v.height
+	if closesAt := newP2VIssue.ClosesAt(); closesAt != 0 {
+		closing, err := getClosing(store, closesAt)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+		}
+		closing = append(closing, tx.Issue)
+		store.Set(ClosingKey(closesAt), wire.BinaryBytes(closing))
+	}
+	issues, err := getIssues(store)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	store.Set(IssuesKey(), wire.BinaryBytes(append(issues, tx.Issue)))
+
+	// Save P2VIssue, charge fee, return
 	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(newP2VIssue))
-	chargeFee(store, ctx, tx.Fee2CreateIssue)
+	chargeFee(accts, ctx, fee2CreateIssue, config.Beneficiary)
 	return abci.OK
 }
 
-func (p2v *P2VPlugin) runTxVote(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+func (p2v *P2VPlugin) runTxVote(store, accts types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
 
 	// Decode tx
-	var tx voteTx
+	var tx VoteTx
 	err := wire.ReadBinaryBytes(txBytes, &tx)
 	if err != nil {
 		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
@@ -206,29 +751,281 @@ func (p2v *P2VPlugin) runTxVote(store types.KVStore, ctx types.CallContext, txBy
 		return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
 	}
 
+	// Is the vote still open?
+	if p2vIssue.IsClosed(p2v.height) {
+		return abci.ErrInternalError.AppendLog("Voting on this issue has closed")
+	}
+
+	votes := tx.Votes
+	if votes == 0 {
+		votes = 1
+	}
+	if votes < 0 || (votes != 1 && p2vIssue.Mode != ModeQuadratic) {
+		return abci.ErrInternalError.AppendLog("P2VTx.Votes must be 1 unless the issue is quadratic")
+	}
+
+	// Secret ballots are only a commitment for now, and ranked
+	// ballots count for their first preference until the issue closes
+	switch {
+	case p2vIssue.IsSecret():
+		if len(tx.Commitment) != sha256.Size || tx.VoteTypeByte != 0 || len(tx.Choice) > 0 || len(tx.Ranking) > 0 {
+			return abci.ErrInternalError.AppendLog("P2VTx.Commitment must be given instead of the ballot on a secret issue")
+		}
+	case len(tx.Commitment) > 0:
+		return abci.ErrInternalError.AppendLog("P2VTx.Commitment is only for secret issues")
+	case p2vIssue.Ranked:
+		if !validRanking(p2vIssue.Choices, tx.Ranking) {
+			return abci.ErrInternalError.AppendLog("P2VTx.Ranking must be distinct choices of the issue")
+		}
+		tx.VoteTypeByte, tx.Choice = TypeByteVoteChoice, tx.Ranking[0]
+	case len(tx.Ranking) > 0:
+		return abci.ErrInternalError.AppendLog("P2VTx.Ranking is only for ranked issues")
+	}
+
+	// Load the ballot of the caller, a second ballot on
+	// a one ballot issue changes the first one instead
+	p2vVoter, err := getVoter(store, tx.Issue, ctx.CallerAddress)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	if len(p2vVoter.Commitment) > 0 {
+		return abci.ErrInternalError.AppendLog("Ballot has already been committed to")
+	}
+	if p2vIssue.OneBallot && p2vVoter.Votes() > 0 {
+		return changeBallot(store, accts, ctx, tx, p2vIssue, p2vVoter)
+	}
+
+	// In quadratic mode the fee is the difference in the price
+	// of all votes by the caller before and after this tx
+	fee := p2vIssue.FeePerVote
+	if p2vIssue.Mode == ModeQuadratic {
+		cast := int64(p2vVoter.Votes())
+		total := cast + int64(votes)
+		if total > maxQuadraticVotes {
+			return abci.ErrInternalError.AppendLog("Too many votes on this issue")
+		}
+		fee, err = scaleCoins(fee, total*total-cast*cast)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error pricing votes: " + err.Error())
+		}
+	}
+
 	// Did the caller provide enough coins?
-	if !ctx.Coins.IsGTE(p2vIssue.FeePerVote) {
+	if !fee.IsValid() || !fee.IsNonnegative() {
+		return abci.ErrInternalError.AppendLog("Fee of the votes is invalid")
+	}
+	if !ctx.Coins.IsGTE(fee) {
 		return abci.ErrInsufficientFunds.AppendLog("Tx Funds insufficient for voting")
 	}
 
-	//Transaction Logic
-	switch tx.VoteTypeByte {
-	case TypeByteVoteFor:
-		p2vIssue.VotesFor += 1
-	case TypeByteVoteAgainst:
-		p2vIssue.VotesAgainst += 1
-	default:
-		return abci.ErrInternalError.AppendLog("P2VTx.VoteTypeByte was not recognized")
+	// In weighted mode everything sent of the weight denomination
+	// above the fee is paid as the weight of the vote
+	var weight int64
+	if p2vIssue.Mode == ModeWeighted {
+		denom := p2vIssue.WeightDenom()
+		weight = amountOf(ctx.Coins, denom) - amountOf(fee, denom)
+		if weight <= 0 {
+			return abci.ErrInsufficientFunds.AppendLog("Tx must send " + denom + " above the fee to weigh the vote")
+		}
+		fee = fee.Plus(types.Coins{{denom, weight}})
 	}
 
-	// Save P2VIssue, charge fee, return
+	//Transaction Logic, the ballot is checked before anything is stored
+	firstBallot := p2vVoter.Votes() == 0
+	if p2vIssue.IsSecret() {
+		p2vVoter.Commitment = tx.Commitment
+		p2vVoter.SealedVotes, p2vVoter.SealedWeight = votes, weight
+	} else {
+		if !p2vIssue.addVotes(tx.VoteTypeByte, tx.Choice, votes, weight) {
+			return abci.ErrInternalError.AppendLog("P2VTx.VoteTypeByte or Choice was not recognized")
+		}
+		p2vVoter.addVotes(tx.VoteTypeByte, tx.Choice, votes, weight)
+	}
+
+	config, err := getConfig(store)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	if firstBallot {
+		err = addVoter(store, tx.Issue, ctx.CallerAddress)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+		}
+	}
+	if p2vIssue.Ranked && !p2vIssue.IsSecret() {
+		addRanking(store, &p2vIssue, &p2vVoter, ctx.CallerAddress, tx.Ranking, votes, weight)
+	}
+	p2vIssue.FeesCollected = p2vIssue.FeesCollected.Plus(fee)
+	p2vVoter.Paid = p2vVoter.Paid.Plus(fee)
+
+	// Save P2VIssue and P2VVoter, charge fee, return
+	store.Set(VoterKey(tx.Issue, ctx.CallerAddress), wire.BinaryBytes(p2vVoter))
+	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
+	chargeFee(accts, ctx, fee, p2vIssue.voteFeePayee(config))
+	return abci.OK
+}
+
+// changeBallot moves all votes of a voter on a one ballot issue to the side
+// of the new tx. Changing costs the fee of one vote but adds no votes.
+func changeBallot(store, accts types.KVStore, ctx types.CallContext, tx VoteTx, p2vIssue P2VIssue, p2vVoter P2VVoter) abci.Result {
+	oldTypeByte, oldChoice, votes, weight := p2vVoter.ballot()
+	switch {
+	case tx.Votes > 1:
+		return abci.ErrInternalError.AppendLog("Cannot add votes to a ballot on this issue")
+	case tx.VoteTypeByte == oldTypeByte && tx.Choice == oldChoice && !p2vIssue.Ranked:
+		return abci.ErrInternalError.AppendLog("Ballot has already been cast this way")
+	case !ctx.Coins.IsGTE(p2vIssue.FeePerVote):
+		return abci.ErrInsufficientFunds.AppendLog("Tx Funds insufficient for voting")
+	}
+
+	// A ranked ballot is replaced by the new ranking
+	var ranking Ranking
+	if p2vIssue.Ranked {
+		var err error
+		ranking, err = getRanking(store, tx.Issue, p2vVoter.Ranking-1)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+		}
+		if sameRanking(ranking.Ranking, tx.Ranking) {
+			return abci.ErrInternalError.AppendLog("Ballot has already been cast this way")
+		}
+	}
+
+	// Take the votes off the old side and count them on the new one
+	p2vIssue.addVotes(oldTypeByte, oldChoice, -votes, -weight)
+	if !p2vIssue.addVotes(tx.VoteTypeByte, tx.Choice, votes, weight) {
+		return abci.ErrInternalError.AppendLog("P2VTx.VoteTypeByte or Choice was not recognized")
+	}
+	p2vVoter.addVotes(oldTypeByte, oldChoice, -votes, -weight)
+	p2vVoter.addVotes(tx.VoteTypeByte, tx.Choice, votes, weight)
+
+	config, err := getConfig(store)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	p2vIssue.FeesCollected = p2vIssue.FeesCollected.Plus(p2vIssue.FeePerVote)
+	p2vVoter.Paid = p2vVoter.Paid.Plus(p2vIssue.FeePerVote)
+
+	if p2vIssue.Ranked {
+		ranking.Ranking = tx.Ranking
+		store.Set(RankingKey(tx.Issue, p2vVoter.Ranking-1), wire.BinaryBytes(ranking))
+	}
+	store.Set(VoterKey(tx.Issue, ctx.CallerAddress), wire.BinaryBytes(p2vVoter))
 	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
-	chargeFee(store, ctx, p2vIssue.FeePerVote)
+	chargeFee(accts, ctx, p2vIssue.FeePerVote, p2vIssue.voteFeePayee(config))
 	return abci.OK
 }
 
+// canScale is true if every amount in coins can be multiplied
+// by the nonnegative n without overflowing
+func canScale(coins types.Coins, n int64) bool {
+	if n < 0 {
+		return false
+	}
+	for _, coin := range coins {
+		if n > 0 && (coin.Amount > math.MaxInt64/n || coin.Amount < math.MinInt64/n) {
+			return false
+		}
+	}
+	return true
+}
+
+// scaleCoins multiplies every amount in coins by the nonnegative n
+func scaleCoins(coins types.Coins, n int64) (types.Coins, error) {
+	if !canScale(coins, n) {
+		return nil, errors.New("amount overflows")
+	}
+	scaled := make(types.Coins, len(coins))
+	for i, coin := range coins {
+		scaled[i] = types.Coin{coin.Denom, coin.Amount * n}
+	}
+	return scaled, nil
+}
+
+// amountOf is how many coins of denom are in coins
+func amountOf(coins types.Coins, denom string) int64 {
+	for _, coin := range coins {
+		if coin.Denom == denom {
+			return coin.Amount
+		}
+	}
+	return 0
+}
+
 func (p2v *P2VPlugin) InitChain(store types.KVStore, vals []*abci.Validator) {}
-func (p2v *P2VPlugin) BeginBlock(store types.KVStore, height uint64)         {}
+
+func (p2v *P2VPlugin) BeginBlock(store types.KVStore, height uint64) {
+	p2v.height = height
+}
+
+// EndBlock closes all issues which accepted their last votes in this block
 func (p2v *P2VPlugin) EndBlock(store types.KVStore, height uint64) []*abci.Validator {
+	accts, store := store, p2v.prefix(store)
+	closing, err := getClosing(store, height)
+	if err != nil {
+		// a broken list must not halt the chain, leave it to be looked into
+		return nil
+	}
+
+	// each issue is closed in a buffer, so one failing half way changes
+	// nothing, and is tried again in the next block
+	var failed []string
+	for _, issue := range closing {
+		buf := newWriteBuffer(accts)
+		err = closeIssue(p2v.prefix(buf), buf, issue)
+		if err != nil {
+			failed = append(failed, issue)
+			continue
+		}
+		buf.flush()
+	}
+	if len(closing) == 0 {
+		return nil
+	}
+	store.Set(ClosingKey(height), nil)
+	if len(failed) > 0 {
+		next, err := getClosing(store, height+1)
+		if err != nil {
+			// don't lose them to a broken list, leave them to be looked into
+			store.Set(ClosingKey(height), wire.BinaryBytes(failed))
+			return nil
+		}
+		store.Set(ClosingKey(height+1), wire.BinaryBytes(append(next, failed...)))
+	}
+	return nil
+}
+
+// closeIssue counts the final outcome of an issue and settles its fees
+func closeIssue(store, accts types.KVStore, issue string) error {
+	p2vIssue, err := getIssue(store, issue)
+	if err != nil {
+		return err
+	}
+	delegated, err := countDelegations(store, accts, &p2vIssue)
+	if err != nil {
+		return err
+	}
+	if p2vIssue.Ranked {
+		rankings, err := getRankings(store, p2vIssue)
+		if err != nil {
+			return err
+		}
+		p2vIssue.closeRanked(append(rankings, delegated...))
+	} else {
+		p2vIssue.close()
+	}
+	if p2vIssue.IsSecret() {
+		err = countForfeits(store, &p2vIssue)
+		if err != nil {
+			return err
+		}
+	}
+	if p2vIssue.IsHeld() {
+		err = settle(store, accts, &p2vIssue)
+		if err != nil {
+			return err
+		}
+	}
+	store.Set(IssueKey(issue), wire.BinaryBytes(p2vIssue))
 	return nil
 }
@@ -1,6 +1,8 @@
 package paytovote
 
 import (
+	"crypto/sha256"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,7 +28,7 @@ func TestP2VPlugin(t *testing.T) {
 	bcApp.SetOption("base/chainID", chainID)
 
 	// Add Counter plugin
-	P2VPlugin := New()
+	P2VPlugin := New("paytovote")
 	bcApp.RegisterPlugin(P2VPlugin)
 
 	// Account initialization
@@ -87,7 +89,7 @@ func TestP2VPlugin(t *testing.T) {
 
 	//test for an issue that shouldn't exist
 	testNoIssue := func(issue string) {
-		_, err := getIssue(bcApp.GetState(), issue)
+		_, err := getIssue(P2VPlugin.prefix(bcApp.GetState()), issue)
 		if err == nil {
 			panic(cmn.Fmt("issue that shouldn't exist was found, issue: %v", issue))
 		}
@@ -95,7 +97,7 @@ func TestP2VPlugin(t *testing.T) {
 
 	//test for an issue that should exist
 	testIssue := func(issue string, expFor, expAgainst int) {
-		p2vIssue, err := getIssue(bcApp.GetState(), issue)
+		p2vIssue, err := getIssue(P2VPlugin.prefix(bcApp.GetState()), issue)
 
 		// return //TODO fix these tests, bad store being accessed
 
@@ -166,3 +168,1143 @@ func TestP2VPlugin(t *testing.T) {
 	testBalance(startBal.Minus(types.Coins{{"issueToken", 1}, {"voteToken", 4}}))
 	testIssue(issue1, 1, 1)
 }
+
+// runTx calls the plugin directly, as basecoin would, taking coins from addr
+func runTx(p2v *P2VPlugin, store types.KVStore, addr []byte, coins types.Coins, txBytes []byte) abci.Result {
+	acc := state.GetAccount(store, addr)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	acc.Balance = acc.Balance.Minus(coins)
+	state.SetAccount(store, addr, acc)
+	ctx := types.NewCallContext(addr, acc, coins)
+	return p2v.RunTx(store, ctx, txBytes)
+}
+
+func TestIssueDeadline(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	addr := []byte("voter")
+	fee := types.Coins{{"voteToken", 1}}
+	state.SetAccount(store, addr, &types.Account{Balance: types.Coins{{"voteToken", 5}}})
+
+	p2v.BeginBlock(store, 10)
+	res := runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "late",
+		FeePerVote: fee,
+		EndHeight:  9,
+	}))
+	assert.True(res.IsErr(), "end height passed")
+
+	res = runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "deadline",
+		FeePerVote: fee,
+		EndHeight:  12,
+	}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "forever",
+		FeePerVote: fee,
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	// vote up to and including the end height
+	for height := uint64(10); height <= 12; height++ {
+		p2v.BeginBlock(store, height)
+		res = runTx(p2v, store, addr, fee, NewVoteTxBytesFrom(VoteTx{Issue: "deadline", VoteTypeByte: TypeByteVoteFor}))
+		assert.True(res.IsOK(), res.String())
+		p2v.EndBlock(store, height)
+	}
+	issue, err := getIssue(p2v.prefix(store), "deadline")
+	assert.Nil(err)
+	assert.Equal(3, issue.VotesFor)
+	assert.Equal(StatusPassed, issue.Status)
+	assert.Nil(p2v.prefix(store).Get(ClosingKey(12)))
+
+	// no more votes after closing, and the fee stays with the voter
+	p2v.BeginBlock(store, 13)
+	res = runTx(p2v, store, addr, fee, NewVoteTxBytesFrom(VoteTx{Issue: "deadline", VoteTypeByte: TypeByteVoteAgainst}))
+	assert.True(res.IsErr(), "voting closed")
+	assert.True(state.GetAccount(store, addr).Balance.IsEqual(types.Coins{{"voteToken", 2}}))
+	issue, _ = getIssue(p2v.prefix(store), "deadline")
+	assert.Equal(0, issue.VotesAgainst)
+
+	// issues without an end height stay open
+	res = runTx(p2v, store, addr, fee, NewVoteTxBytesFrom(VoteTx{Issue: "forever", VoteTypeByte: TypeByteVoteAgainst}))
+	assert.True(res.IsOK(), res.String())
+	issue, _ = getIssue(p2v.prefix(store), "forever")
+	assert.Equal(StatusOpen, issue.Status)
+	assert.Equal(1, issue.VotesAgainst)
+}
+
+func TestIssueOutcome(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		votesFor, votesAgainst int
+		quorum                 int
+		threshold              Threshold
+		expected               byte
+	}{
+		{0, 0, 0, Threshold{}, StatusRejected},
+		{2, 1, 0, Threshold{}, StatusPassed},
+		{2, 2, 0, Threshold{}, StatusRejected},
+		{2, 1, 4, Threshold{}, StatusNoQuorum},
+		{3, 1, 4, Threshold{}, StatusPassed},
+		{2, 1, 0, Threshold{2, 3}, StatusPassed},
+		{3, 2, 0, Threshold{2, 3}, StatusRejected},
+		{1, 1, 0, Threshold{1, 2}, StatusPassed},
+		{0, 0, 0, Threshold{1, 2}, StatusRejected},
+	}
+	for i, tc := range cases {
+		issue := P2VIssue{
+			VotesFor:     tc.votesFor,
+			VotesAgainst: tc.votesAgainst,
+			Quorum:       tc.quorum,
+			Threshold:    tc.threshold,
+		}
+		issue.close()
+		assert.Equal(tc.expected, issue.Status, "%d", i)
+	}
+
+	// weighted tallies near the int64 limit must not overflow
+	huge := int64(math.MaxInt64 / 2)
+	assert.True(Threshold{2, 3}.Passes(huge, huge+1))
+	assert.False(Threshold{2, 3}.Passes(huge/2, huge))
+	assert.True(Threshold{}.Passes(math.MaxInt64, math.MaxInt64))
+	assert.False(Threshold{}.Passes(huge, math.MaxInt64))
+	assert.True(Threshold{999, 1000}.Passes(math.MaxInt64, math.MaxInt64))
+
+	// bad thresholds are rejected when creating the issue
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	for _, threshold := range []Threshold{{3, 2}, {1, 0}, {0, 3}, {-1, 2}} {
+		res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+			Issue:     "bad threshold",
+			Threshold: threshold,
+		}))
+		assert.True(res.IsErr(), "%v", threshold)
+	}
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:  "bad quorum",
+		Quorum: -1,
+	}))
+	assert.True(res.IsErr(), "negative quorum")
+}
+
+func TestWeightedVotes(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	alice, bob := []byte("alice"), []byte("bob")
+	start := types.Coins{{"stake", 100}, {"voteToken", 10}}
+	state.SetAccount(store, alice, &types.Account{Balance: start})
+	state.SetAccount(store, bob, &types.Account{Balance: start})
+
+	// the weight denom goes with, and only with, a weighted issue
+	res := runTx(p2v, store, alice, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue: "no denom",
+		Mode:  ModeWeighted,
+	}))
+	assert.True(res.IsErr(), "weighted without a denom")
+	res = runTx(p2v, store, alice, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:       "not weighted",
+		WeightDenom: "stake",
+	}))
+	assert.True(res.IsErr(), "denom without weighting")
+
+	res = runTx(p2v, store, alice, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:       "weighted",
+		FeePerVote:  types.Coins{{"stake", 1}, {"voteToken", 1}},
+		Mode:        ModeWeighted,
+		WeightDenom: "stake",
+		EndHeight:   5,
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	// nothing above the fee is no weight at all
+	res = runTx(p2v, store, alice, types.Coins{{"stake", 1}, {"voteToken", 1}},
+		NewVoteTxBytesFrom(VoteTx{Issue: "weighted", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsErr(), "no weight")
+	assert.True(state.GetAccount(store, alice).Balance.IsEqual(start))
+
+	// alice pays 30 stake for the issue, the extra voteToken is returned
+	res = runTx(p2v, store, alice, types.Coins{{"stake", 31}, {"voteToken", 2}},
+		NewVoteTxBytesFrom(VoteTx{Issue: "weighted", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsOK(), res.String())
+	assert.True(state.GetAccount(store, alice).Balance.IsEqual(types.Coins{{"stake", 69}, {"voteToken", 9}}))
+
+	// bob votes against twice with less weight
+	for i := 0; i < 2; i++ {
+		res = runTx(p2v, store, bob, types.Coins{{"stake", 11}, {"voteToken", 1}},
+			NewVoteTxBytesFrom(VoteTx{Issue: "weighted", VoteTypeByte: TypeByteVoteAgainst}))
+		assert.True(res.IsOK(), res.String())
+	}
+
+	issue, err := getIssue(p2v.prefix(store), "weighted")
+	assert.Nil(err)
+	assert.Equal(1, issue.VotesFor)
+	assert.Equal(2, issue.VotesAgainst)
+	assert.Equal(types.Coin{"stake", 30}, issue.WeightFor)
+	assert.Equal(types.Coin{"stake", 20}, issue.WeightAgainst)
+
+	// the weight decides the outcome, not the number of votes
+	p2v.EndBlock(store, 5)
+	issue, _ = getIssue(p2v.prefix(store), "weighted")
+	assert.Equal(StatusPassed, issue.Status)
+}
+
+func TestQuadraticVotes(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	addr := []byte("voter")
+	state.SetAccount(store, addr, &types.Account{Balance: types.Coins{{"voteToken", 100}}})
+	balance := func() types.Coins { return state.GetAccount(store, addr).Balance }
+
+	res := runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "quadratic",
+		FeePerVote: types.Coins{{"voteToken", 2}},
+		Mode:       ModeQuadratic,
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	// 3 votes cost 3^2 * 2
+	vote := VoteTx{Issue: "quadratic", VoteTypeByte: TypeByteVoteFor, Votes: 3}
+	res = runTx(p2v, store, addr, types.Coins{{"voteToken", 17}}, NewVoteTxBytesFrom(vote))
+	assert.True(res.IsErr(), "insufficient funds")
+	res = runTx(p2v, store, addr, types.Coins{{"voteToken", 20}}, NewVoteTxBytesFrom(vote))
+	assert.True(res.IsOK(), res.String())
+	assert.True(balance().IsEqual(types.Coins{{"voteToken", 82}}))
+
+	// 1 more costs the difference between 4^2 and 3^2 votes, even against
+	vote = VoteTx{Issue: "quadratic", VoteTypeByte: TypeByteVoteAgainst}
+	res = runTx(p2v, store, addr, types.Coins{{"voteToken", 14}}, NewVoteTxBytesFrom(vote))
+	assert.True(res.IsOK(), res.String())
+	assert.True(balance().IsEqual(types.Coins{{"voteToken", 68}}))
+
+	voter, err := getVoter(p2v.prefix(store), "quadratic", addr)
+	assert.Nil(err)
+	assert.Equal(4, voter.Votes())
+	issue, _ := getIssue(p2v.prefix(store), "quadratic")
+	assert.Equal(3, issue.VotesFor)
+	assert.Equal(1, issue.VotesAgainst)
+
+	// several votes at once are only allowed on quadratic issues
+	res = runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "plain",
+		FeePerVote: types.Coins{{"voteToken", 2}},
+	}))
+	assert.True(res.IsOK(), res.String())
+	vote = VoteTx{Issue: "plain", VoteTypeByte: TypeByteVoteFor, Votes: 2}
+	res = runTx(p2v, store, addr, types.Coins{{"voteToken", 8}}, NewVoteTxBytesFrom(vote))
+	assert.True(res.IsErr(), "several votes")
+	assert.True(balance().IsEqual(types.Coins{{"voteToken", 68}}))
+
+	// the price of the most votes must fit, or voters could be paid to vote
+	res = runTx(p2v, store, addr, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "pricey",
+		FeePerVote: types.Coins{{"voteToken", math.MaxInt64 >> 30}},
+		Mode:       ModeQuadratic,
+	}))
+	assert.True(res.IsErr(), "fee overflows")
+	_, err = scaleCoins(types.Coins{{"voteToken", math.MaxInt64/4 + 1}}, 4)
+	assert.NotNil(err)
+
+	// issues stored without the check cannot be voted into a negative fee
+	overflowing := newP2VIssue(CreateIssueTx{
+		Issue:      "overflow",
+		FeePerVote: types.Coins{{"voteToken", math.MaxInt64 >> 30}},
+		Mode:       ModeQuadratic,
+	})
+	p2v.prefix(store).Set(IssueKey("overflow"), wire.BinaryBytes(overflowing))
+	vote = VoteTx{Issue: "overflow", VoteTypeByte: TypeByteVoteFor, Votes: maxQuadraticVotes}
+	res = runTx(p2v, store, addr, nil, NewVoteTxBytesFrom(vote))
+	assert.True(res.IsErr(), "fee overflows")
+	assert.True(balance().IsEqual(types.Coins{{"voteToken", 68}}))
+	issue, _ = getIssue(p2v.prefix(store), "overflow")
+	assert.Equal(0, issue.VotesFor)
+}
+
+func TestOneBallot(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	alice, bob := []byte("alice"), []byte("bob")
+	start := types.Coins{{"stake", 100}, {"voteToken", 10}}
+	state.SetAccount(store, alice, &types.Account{Balance: start})
+	state.SetAccount(store, bob, &types.Account{Balance: start})
+	fee := types.Coins{{"voteToken", 1}}
+
+	res := runTx(p2v, store, alice, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:       "membership",
+		FeePerVote:  fee,
+		Mode:        ModeWeighted,
+		WeightDenom: "stake",
+		OneBallot:   true,
+		EndHeight:   3,
+	}))
+	assert.True(res.IsOK(), res.String())
+	vote := func(addr []byte, coins types.Coins, voteTypeByte byte) abci.Result {
+		return runTx(p2v, store, addr, coins, NewVoteTxBytesFrom(VoteTx{Issue: "membership", VoteTypeByte: voteTypeByte}))
+	}
+	tally := func(votesFor, votesAgainst int, weightFor, weightAgainst int64) {
+		issue, err := getIssue(p2v.prefix(store), "membership")
+		assert.Nil(err)
+		assert.Equal(votesFor, issue.VotesFor)
+		assert.Equal(votesAgainst, issue.VotesAgainst)
+		assert.Equal(weightFor, issue.WeightFor.Amount)
+		assert.Equal(weightAgainst, issue.WeightAgainst.Amount)
+	}
+
+	p2v.BeginBlock(store, 1)
+	res = vote(alice, types.Coins{{"stake", 40}, {"voteToken", 1}}, TypeByteVoteFor)
+	assert.True(res.IsOK(), res.String())
+	res = vote(bob, types.Coins{{"stake", 30}, {"voteToken", 1}}, TypeByteVoteAgainst)
+	assert.True(res.IsOK(), res.String())
+	tally(1, 1, 40, 30)
+
+	// a second ballot the same way is refused
+	res = vote(alice, types.Coins{{"stake", 40}, {"voteToken", 1}}, TypeByteVoteFor)
+	assert.True(res.IsErr(), "second ballot")
+	tally(1, 1, 40, 30)
+
+	// bob changes sides, the weight moves with the ballot and extra stake is returned
+	res = vote(bob, types.Coins{{"stake", 10}, {"voteToken", 1}}, TypeByteVoteFor)
+	assert.True(res.IsOK(), res.String())
+	tally(2, 0, 70, 0)
+	assert.True(state.GetAccount(store, bob).Balance.IsEqual(types.Coins{{"stake", 70}, {"voteToken", 8}}))
+	voter, err := getVoter(p2v.prefix(store), "membership", bob)
+	assert.Nil(err)
+	assert.Equal(1, voter.VotesFor)
+	assert.Equal(int64(30), voter.WeightFor)
+	assert.Equal(0, voter.VotesAgainst)
+	assert.Equal(int64(0), voter.WeightAgainst)
+
+	// and can change back until the deadline, but not after
+	p2v.BeginBlock(store, 3)
+	res = vote(bob, fee, TypeByteVoteAgainst)
+	assert.True(res.IsOK(), res.String())
+	tally(1, 1, 40, 30)
+	p2v.EndBlock(store, 3)
+	p2v.BeginBlock(store, 4)
+	res = vote(bob, fee, TypeByteVoteFor)
+	assert.True(res.IsErr(), "voting closed")
+	tally(1, 1, 40, 30)
+}
+
+func TestMultipleChoice(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	fee := types.Coins{{"voteToken", 1}}
+	choices := []string{"alpha", "beta", "gamma", AbstainChoice}
+
+	for _, bad := range [][]string{{"alpha"}, {"alpha", AbstainChoice}, {"alpha", "alpha"}, {"alpha", ""}} {
+		res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+			Issue:   "bad choices",
+			Choices: bad,
+		}))
+		assert.True(res.IsErr(), "%v", bad)
+	}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "grants",
+		FeePerVote: fee,
+		Choices:    choices,
+		Quorum:     5,
+		OneBallot:  true,
+		EndHeight:  1,
+	}))
+	assert.True(res.IsOK(), res.String())
+	vote := func(voter string, choice string) abci.Result {
+		addr := []byte(voter)
+		state.SetAccount(store, addr, &types.Account{Balance: fee})
+		return runTx(p2v, store, addr, fee, NewVoteTxBytesFrom(VoteTx{
+			Issue:        "grants",
+			VoteTypeByte: TypeByteVoteChoice,
+			Choice:       choice,
+		}))
+	}
+
+	// yes/no votes and unknown choices are refused
+	res = runTx(p2v, store, []byte("a"), fee, NewVoteTxBytesFrom(VoteTx{Issue: "grants", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsErr(), "vote for")
+	assert.True(vote("a", "delta").IsErr(), "unknown choice")
+	voters, err := getVoters(p2v.prefix(store), "grants")
+	assert.Nil(err)
+	assert.Empty(voters, "refused ballots are not recorded")
+
+	for voter, choice := range map[string]string{"a": "alpha", "b": "beta", "c": "beta", "d": AbstainChoice, "e": "gamma"} {
+		res = vote(voter, choice)
+		assert.True(res.IsOK(), res.String())
+	}
+	// a moves from alpha to beta
+	res = vote("a", "beta")
+	assert.True(res.IsOK(), res.String())
+
+	issue, err := getIssue(p2v.prefix(store), "grants")
+	assert.Nil(err)
+	assert.Equal([]Choice{{"alpha", 0, 0}, {"beta", 3, 0}, {"gamma", 1, 0}, {AbstainChoice, 1, 0}}, issue.Choices)
+	assert.Equal(5, issue.VotesCast())
+
+	p2v.EndBlock(store, 1)
+	issue, _ = getIssue(p2v.prefix(store), "grants")
+	assert.Equal(StatusPassed, issue.Status)
+	assert.Equal("beta", issue.Winner)
+
+	// ties and thresholds leave no winner
+	cases := []struct {
+		tallies   []int
+		threshold Threshold
+		winner    string
+	}{
+		{[]int{2, 2, 1, 0}, Threshold{}, ""},
+		{[]int{2, 1, 1, 5}, Threshold{}, "alpha"},
+		{[]int{2, 1, 1, 0}, Threshold{1, 2}, "alpha"},
+		{[]int{2, 1, 1, 0}, Threshold{2, 3}, ""},
+	}
+	for i, tc := range cases {
+		issue := P2VIssue{Choices: newChoices(choices), Threshold: tc.threshold}
+		for j, votes := range tc.tallies {
+			issue.Choices[j].Votes = votes
+		}
+		issue.close()
+		assert.Equal(tc.winner, issue.Winner, "%d", i)
+		assert.Equal(len(tc.winner) > 0, issue.Status == StatusPassed, "%d", i)
+	}
+}
+
+func TestRankedChoice(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	fee := types.Coins{{"voteToken", 1}}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:  "unranked",
+		Ranked: true,
+	}))
+	assert.True(res.IsErr(), "no choices")
+
+	res = runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "council",
+		FeePerVote: fee,
+		Choices:    []string{"ann", "ben", "cat", AbstainChoice},
+		Ranked:     true,
+		OneBallot:  true,
+		EndHeight:  1,
+	}))
+	assert.True(res.IsOK(), res.String())
+	vote := func(voter string, ranking ...string) abci.Result {
+		addr := []byte(voter)
+		state.SetAccount(store, addr, &types.Account{Balance: fee})
+		return runTx(p2v, store, addr, fee, NewVoteTxBytesFrom(VoteTx{Issue: "council", Ranking: ranking}))
+	}
+
+	for _, bad := range [][]string{{}, {"dan"}, {"ann", "ann"}, {"ann", AbstainChoice}} {
+		assert.True(vote("x", bad...).IsErr(), "%v", bad)
+	}
+
+	// ann leads on first preferences, but cat's voters prefer ben
+	ballots := [][]string{
+		{"ann", "cat"}, {"ann"}, {"ann", "ben"}, {"ann"},
+		{"ben", "ann"}, {"ben", "cat"}, {"ben"},
+		{"cat", "ben"}, {"cat"},
+		{AbstainChoice},
+	}
+	for i, ranking := range ballots {
+		res = vote(string(rune('a'+i)), ranking...)
+		assert.True(res.IsOK(), res.String())
+	}
+	// the voter of {"cat"} changes their ranking
+	assert.True(vote("i", "cat").IsErr(), "same ranking")
+	res = vote("i", "cat", "ben")
+	assert.True(res.IsOK(), res.String())
+
+	issue, err := getIssue(p2v.prefix(store), "council")
+	assert.Nil(err)
+	assert.Equal(10, issue.Rankings)
+	assert.Equal([]Choice{{"ann", 4, 0}, {"ben", 3, 0}, {"cat", 2, 0}, {AbstainChoice, 1, 0}}, issue.Choices)
+
+	p2v.EndBlock(store, 1)
+	issue, _ = getIssue(p2v.prefix(store), "council")
+	assert.Equal(StatusPassed, issue.Status)
+	assert.Equal("ben", issue.Winner)
+	if assert.Equal(2, len(issue.Rounds)) {
+		assert.Equal([]string{"cat"}, issue.Rounds[0].Eliminated)
+		assert.Equal([]Choice{{"ann", 4, 0}, {"ben", 5, 0}}, issue.Rounds[1].Tallies)
+	}
+
+	// a tie at the end leaves no winner
+	choices := newChoices([]string{"ann", "ben"})
+	winner, _, _, rounds := runoff(choices, []Ranking{{Ranking: []string{"ann"}, Votes: 1}, {Ranking: []string{"ben"}, Votes: 1}}, false)
+	assert.Equal("", winner)
+	assert.Equal(1, len(rounds))
+}
+
+func TestFeeBeneficiary(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	treasury := []byte("treasury-address-20b")
+	proposer := []byte("proposer-address-20b")
+	voter := []byte("voter")
+	state.SetAccount(store, voter, &types.Account{Balance: types.Coins{{"issueToken", 10}, {"voteToken", 10}}})
+	balance := func(addr []byte) types.Coins {
+		acc := state.GetAccount(store, addr)
+		if acc == nil {
+			return nil
+		}
+		return acc.Balance
+	}
+
+	assert.Contains(p2v.SetOption(store, OptionBeneficiary, "1234"), "Invalid")
+	log := p2v.SetOption(store, OptionBeneficiary, cmn.Fmt("%X", treasury))
+	assert.Equal(cmn.Fmt("Set beneficiary: %X", treasury), log)
+
+	// creation fees always go to the treasury
+	fee2Create := types.Coins{{"issueToken", 2}}
+	res := runTx(p2v, store, voter, fee2Create, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:           "treasury",
+		FeePerVote:      types.Coins{{"voteToken", 1}},
+		Fee2CreateIssue: fee2Create,
+	}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, voter, fee2Create, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:           "proposal",
+		FeePerVote:      types.Coins{{"voteToken", 2}},
+		Fee2CreateIssue: fee2Create,
+		Beneficiary:     proposer,
+	}))
+	assert.True(res.IsOK(), res.String())
+	assert.True(balance(treasury).IsEqual(types.Coins{{"issueToken", 4}}))
+
+	// vote fees go to the issue's beneficiary, or else the treasury
+	res = runTx(p2v, store, voter, types.Coins{{"voteToken", 3}},
+		NewVoteTxBytesFrom(VoteTx{Issue: "treasury", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsOK(), res.String())
+	for i := 0; i < 2; i++ {
+		res = runTx(p2v, store, voter, types.Coins{{"voteToken", 2}},
+			NewVoteTxBytesFrom(VoteTx{Issue: "proposal", VoteTypeByte: TypeByteVoteFor}))
+		assert.True(res.IsOK(), res.String())
+	}
+
+	assert.True(balance(voter).IsEqual(types.Coins{{"issueToken", 6}, {"voteToken", 5}}))
+	assert.True(balance(treasury).IsEqual(types.Coins{{"issueToken", 4}, {"voteToken", 1}}))
+	assert.True(balance(proposer).IsEqual(types.Coins{{"voteToken", 4}}))
+	issue, _ := getIssue(p2v.prefix(store), "treasury")
+	assert.True(issue.FeesCollected.IsEqual(types.Coins{{"voteToken", 1}}))
+	issue, _ = getIssue(p2v.prefix(store), "proposal")
+	assert.True(issue.FeesCollected.IsEqual(types.Coins{{"voteToken", 4}}))
+
+	// without a treasury fees are burned
+	p2v.SetOption(store, OptionBeneficiary, "")
+	res = runTx(p2v, store, voter, types.Coins{{"voteToken", 1}},
+		NewVoteTxBytesFrom(VoteTx{Issue: "treasury", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsOK(), res.String())
+	assert.True(balance(treasury).IsEqual(types.Coins{{"issueToken", 4}, {"voteToken", 1}}))
+}
+
+func TestSettlement(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	treasury := []byte("treasury-address-20b")
+	p2v.SetOption(store, OptionBeneficiary, cmn.Fmt("%X", treasury))
+	fee := types.Coins{{"voteToken", 2}}
+	start := types.Coins{{"voteToken", 10}}
+	balance := func(addr string) types.Coins {
+		acc := state.GetAccount(store, []byte(addr))
+		if acc == nil {
+			return nil
+		}
+		return acc.Balance
+	}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "not one ballot",
+		Settlement: SettleRefundLosers,
+	}))
+	assert.True(res.IsErr(), "held fees need one ballot")
+
+	cases := []struct {
+		settlement byte
+		quorum     int
+		refunded   []string
+	}{
+		{SettleRefundLosers, 0, []string{"carol"}},
+		{SettleRefundWinners, 0, []string{"alice", "bob"}},
+		{SettleRefundWinners, 4, []string{"alice", "bob", "carol"}},
+	}
+	for i, tc := range cases {
+		issue := cmn.Fmt("issue %d", i)
+		height := uint64(i + 1)
+		res = runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+			Issue:      issue,
+			FeePerVote: fee,
+			OneBallot:  true,
+			Settlement: tc.settlement,
+			Quorum:     tc.quorum,
+			EndHeight:  height,
+		}))
+		assert.True(res.IsOK(), res.String())
+
+		// carol changes ballot, paying another fee
+		ballots := []struct {
+			voter        string
+			voteTypeByte byte
+		}{
+			{"alice", TypeByteVoteFor}, {"bob", TypeByteVoteFor},
+			{"carol", TypeByteVoteFor}, {"carol", TypeByteVoteAgainst},
+		}
+		for _, ballot := range ballots {
+			state.SetAccount(store, []byte(ballot.voter), &types.Account{Balance: start})
+			res = runTx(p2v, store, []byte(ballot.voter), fee,
+				NewVoteTxBytesFrom(VoteTx{Issue: issue, VoteTypeByte: ballot.voteTypeByte}))
+			assert.True(res.IsOK(), res.String())
+		}
+		// fees are held until the issue closes
+		assert.True(balance("carol").IsEqual(types.Coins{{"voteToken", 8}}), "%d", i)
+		treasuryBefore := balance(string(treasury))
+
+		p2v.EndBlock(store, height)
+		p2vIssue, _ := getIssue(p2v.prefix(store), issue)
+		assert.True(p2vIssue.FeesCollected.IsEqual(types.Coins{{"voteToken", 8}}), "%d", i)
+
+		var refunded int64
+		for _, voter := range []string{"alice", "bob", "carol"} {
+			expected := types.Coins{{"voteToken", 8}}
+			if findString(tc.refunded, voter) >= 0 {
+				paid := int64(2)
+				if voter == "carol" {
+					paid = 4
+				}
+				expected = types.Coins{{"voteToken", 8 + paid}}
+				refunded += paid
+			}
+			assert.True(balance(voter).IsEqual(expected), "%d %v %v", i, voter, balance(voter))
+		}
+		assert.Equal(types.Coins{{"voteToken", refunded}}, p2vIssue.Refunded, "%d", i)
+		if refunded < 8 {
+			assert.True(balance(string(treasury)).IsEqual(treasuryBefore.Plus(types.Coins{{"voteToken", 8 - refunded}})), "%d", i)
+		} else {
+			assert.True(balance(string(treasury)).IsEqual(treasuryBefore), "%d", i)
+		}
+	}
+}
+
+func TestChainParameters(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	creator := []byte("creator")
+	state.SetAccount(store, creator, &types.Account{Balance: types.Coins{{"issueToken", 10}}})
+
+	assert.Contains(p2v.SetOption(store, OptionCreateFee, "2voteToken,1issueToken"), "Invalid")
+	assert.Contains(p2v.SetOption(store, OptionMaxIssueLength, "-1"), "Invalid")
+	assert.Contains(p2v.SetOption(store, OptionVotingPeriod, "soon"), "Invalid")
+	assert.Equal("Unknown key: no-such-option", p2v.SetOption(store, "no-such-option", "1"))
+	assert.Equal("Set create-fee: 2issueToken", p2v.SetOption(store, OptionCreateFee, "2issueToken"))
+	p2v.SetOption(store, OptionFeeDenoms, "voteToken, stake")
+	p2v.SetOption(store, OptionMaxIssueLength, "8")
+	p2v.SetOption(store, OptionVotingPeriod, "100")
+	config, err := getConfig(p2v.prefix(store))
+	assert.Nil(err)
+	assert.Equal([]string{"voteToken", "stake"}, config.FeeDenoms)
+
+	create := func(tx CreateIssueTx, coins types.Coins) abci.Result {
+		tx.Fee2CreateIssue = coins
+		return runTx(p2v, store, creator, coins, NewCreateIssueTxBytesFrom(tx))
+	}
+	fee := types.Coins{{"issueToken", 2}}
+	vote := types.Coins{{"voteToken", 1}}
+
+	assert.True(create(CreateIssueTx{Issue: "free", FeePerVote: vote}, nil).IsErr(), "no fee")
+	assert.True(create(CreateIssueTx{Issue: "cheap", FeePerVote: vote}, types.Coins{{"issueToken", 1}}).IsErr(), "low fee")
+	assert.True(create(CreateIssueTx{Issue: "much too long", FeePerVote: vote}, fee).IsErr(), "long name")
+	assert.True(create(CreateIssueTx{Issue: "gold", FeePerVote: types.Coins{{"gold", 1}}}, fee).IsErr(), "fee denom")
+	assert.True(create(CreateIssueTx{Issue: "gold", FeePerVote: vote, Mode: ModeWeighted, WeightDenom: "gold"}, fee).IsErr(), "weight denom")
+	assert.True(state.GetAccount(store, creator).Balance.IsEqual(types.Coins{{"issueToken", 10}}))
+
+	// only the chain's fee is charged, and the issue closes after the voting period
+	p2v.BeginBlock(store, 7)
+	res := create(CreateIssueTx{Issue: "default", FeePerVote: vote}, types.Coins{{"issueToken", 3}})
+	assert.True(res.IsOK(), res.String())
+	assert.True(state.GetAccount(store, creator).Balance.IsEqual(types.Coins{{"issueToken", 8}}))
+	issue, err := getIssue(p2v.prefix(store), "default")
+	assert.Nil(err)
+	assert.Equal(uint64(107), issue.EndHeight)
+	closing, _ := getClosing(p2v.prefix(store), 107)
+	assert.Equal([]string{"default"}, closing)
+
+	res = create(CreateIssueTx{Issue: "explicit", FeePerVote: vote, EndHeight: 20}, fee)
+	assert.True(res.IsOK(), res.String())
+	issue, _ = getIssue(p2v.prefix(store), "explicit")
+	assert.Equal(uint64(20), issue.EndHeight)
+
+	// only issues which were created are listed
+	issues, err := getIssues(p2v.prefix(store))
+	assert.Nil(err)
+	assert.Equal([]string{"default", "explicit"}, issues)
+
+	// without a create fee, the fee offered must be in the fee denoms
+	state.SetAccount(store, creator, &types.Account{Balance: types.Coins{{"issueToken", 10}, {"voteToken", 10}}})
+	assert.Equal("Set create-fee: ", p2v.SetOption(store, OptionCreateFee, ""))
+	res = create(CreateIssueTx{Issue: "offered", FeePerVote: vote}, fee)
+	assert.True(res.IsErr(), "create fee denom")
+	res = create(CreateIssueTx{Issue: "offered", FeePerVote: vote}, types.Coins{{"voteToken", 2}})
+	assert.True(res.IsOK(), res.String())
+	assert.True(state.GetAccount(store, creator).Balance.IsEqual(types.Coins{{"issueToken", 10}, {"voteToken", 8}}))
+}
+
+func TestSecretBallots(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	treasury := []byte("treasury-address-20b")
+	p2v.SetOption(store, OptionBeneficiary, cmn.Fmt("%X", treasury))
+	fee := types.Coins{{"voteToken", 2}}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:        "early reveal",
+		EndHeight:    5,
+		RevealHeight: 5,
+	}))
+	assert.True(res.IsErr(), "reveals must come after voting")
+	res = runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:        "secret",
+		FeePerVote:   fee,
+		EndHeight:    5,
+		RevealHeight: 10,
+		Settlement:   SettleRefundLosers,
+	}))
+	assert.True(res.IsOK(), res.String())
+	closing, _ := getClosing(p2v.prefix(store), 10)
+	assert.Equal([]string{"secret"}, closing)
+
+	ballots := map[string]RevealTx{
+		"alice": {Issue: "secret", VoteTypeByte: TypeByteVoteFor, Salt: []byte("alice's salt")},
+		"bob":   {Issue: "secret", VoteTypeByte: TypeByteVoteAgainst, Salt: []byte("bob's salt")},
+		"carol": {Issue: "secret", VoteTypeByte: TypeByteVoteFor, Salt: []byte("carol's salt")},
+	}
+	for voter, ballot := range ballots {
+		state.SetAccount(store, []byte(voter), &types.Account{Balance: types.Coins{{"voteToken", 10}}})
+		res = runTx(p2v, store, []byte(voter), fee,
+			NewVoteTxBytesFrom(VoteTx{Issue: "secret", Commitment: ballot.Commitment([]byte(voter))}))
+		assert.True(res.IsOK(), res.String())
+	}
+	res = runTx(p2v, store, []byte("alice"), fee,
+		NewVoteTxBytesFrom(VoteTx{Issue: "secret", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsErr(), "open ballot on a secret issue")
+	res = runTx(p2v, store, []byte("alice"), fee,
+		NewVoteTxBytesFrom(VoteTx{Issue: "secret", Commitment: ballots["bob"].Commitment([]byte("bob"))}))
+	assert.True(res.IsErr(), "second commitment")
+	res = runTx(p2v, store, []byte("alice"), nil, NewRevealTxBytes(ballots["alice"]))
+	assert.True(res.IsErr(), "reveal while voting is open")
+
+	// nothing is counted until revealed
+	p2vIssue, _ := getIssue(p2v.prefix(store), "secret")
+	assert.Equal(0, p2vIssue.VotesCast())
+	assert.True(p2vIssue.FeesCollected.IsEqual(types.Coins{{"voteToken", 6}}))
+
+	p2v.BeginBlock(store, 6)
+	res = runTx(p2v, store, []byte("alice"), fee,
+		NewVoteTxBytesFrom(VoteTx{Issue: "secret", Commitment: ballots["alice"].Commitment([]byte("alice"))}))
+	assert.True(res.IsErr(), "commitment after voting closed")
+	wrongSalt := ballots["bob"]
+	wrongSalt.Salt = []byte("guess")
+	res = runTx(p2v, store, []byte("bob"), nil, NewRevealTxBytes(wrongSalt))
+	assert.True(res.IsErr(), "reveal must match the commitment")
+	res = runTx(p2v, store, []byte("dave"), nil, NewRevealTxBytes(ballots["alice"]))
+	assert.True(res.IsErr(), "reveal without a commitment")
+	for _, voter := range []string{"alice", "bob"} {
+		res = runTx(p2v, store, []byte(voter), nil, NewRevealTxBytes(ballots[voter]))
+		assert.True(res.IsOK(), res.String())
+	}
+	res = runTx(p2v, store, []byte("alice"), nil, NewRevealTxBytes(ballots["alice"]))
+	assert.True(res.IsErr(), "second reveal")
+
+	// issue closes at the reveal height, forfeiting carol's unrevealed ballot
+	p2v.EndBlock(store, 5)
+	p2vIssue, _ = getIssue(p2v.prefix(store), "secret")
+	assert.Equal(StatusOpen, p2vIssue.Status)
+	p2v.EndBlock(store, 10)
+	p2vIssue, _ = getIssue(p2v.prefix(store), "secret")
+	assert.Equal(1, p2vIssue.VotesFor)
+	assert.Equal(1, p2vIssue.VotesAgainst)
+	assert.Equal(StatusRejected, p2vIssue.Status)
+	assert.Equal(1, p2vIssue.Unrevealed)
+	assert.True(p2vIssue.Forfeited.IsEqual(fee))
+
+	// alice lost and is refunded, bob won and carol forfeited their fee
+	assert.True(state.GetAccount(store, []byte("alice")).Balance.IsEqual(types.Coins{{"voteToken", 10}}))
+	assert.True(state.GetAccount(store, []byte("bob")).Balance.IsEqual(types.Coins{{"voteToken", 8}}))
+	assert.True(state.GetAccount(store, []byte("carol")).Balance.IsEqual(types.Coins{{"voteToken", 8}}))
+	assert.True(state.GetAccount(store, treasury).Balance.IsEqual(types.Coins{{"voteToken", 4}}))
+}
+
+func TestCommitmentReplay(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	fee := types.Coins{{"voteToken", 2}}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:        "secret",
+		FeePerVote:   fee,
+		EndHeight:    5,
+		RevealHeight: 10,
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	// mallory copies alice's commitment while it is pending
+	ballot := RevealTx{Issue: "secret", VoteTypeByte: TypeByteVoteFor, Salt: []byte("alice's salt")}
+	commitment := NewVoteTxBytesFrom(VoteTx{Issue: "secret", Commitment: ballot.Commitment([]byte("alice"))})
+	for _, voter := range []string{"alice", "mallory"} {
+		state.SetAccount(store, []byte(voter), &types.Account{Balance: types.Coins{{"voteToken", 10}}})
+		res = runTx(p2v, store, []byte(voter), fee, commitment)
+		assert.True(res.IsOK(), res.String())
+	}
+
+	// and replays alice's reveal, which only alice can make
+	p2v.BeginBlock(store, 6)
+	res = runTx(p2v, store, []byte("mallory"), nil, NewRevealTxBytes(ballot))
+	assert.True(res.IsErr(), "reveal of another voter's commitment")
+	res = runTx(p2v, store, []byte("alice"), nil, NewRevealTxBytes(ballot))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, []byte("mallory"), nil, NewRevealTxBytes(ballot))
+	assert.True(res.IsErr(), "replayed reveal")
+
+	p2vIssue, _ := getIssue(p2v.prefix(store), "secret")
+	assert.Equal(1, p2vIssue.VotesFor)
+}
+
+func TestDelegation(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	addr := func(name string) []byte {
+		return []byte(cmn.Fmt("%-20s", name))
+	}
+	delegate := func(delegator, delegate, issue string) abci.Result {
+		tx := DelegateTx{Issue: issue}
+		if len(delegate) > 0 {
+			tx.Delegate = addr(delegate)
+		}
+		return runTx(p2v, store, addr(delegator), nil, NewDelegateTxBytes(tx))
+	}
+	vote := func(voter string, tx VoteTx) {
+		res := runTx(p2v, store, addr(voter), nil, NewVoteTxBytesFrom(tx))
+		assert.True(res.IsOK(), res.String())
+	}
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:     "budget",
+		EndHeight: 5,
+	}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:     "chair",
+		EndHeight: 5,
+		Choices:   []string{"ann", "ben"},
+		Ranked:    true,
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	assert.True(delegate("alice", "alice", "").IsErr(), "delegate to self")
+	assert.True(runTx(p2v, store, addr("alice"), nil,
+		NewDelegateTxBytes(DelegateTx{Delegate: []byte("short")})).IsErr(), "bad address")
+	assert.True(delegate("alice", "bob", "no such issue").IsErr(), "unknown issue")
+
+	// carol delegates to dave on the budget only, erin overrides the
+	// delegation by voting on the budget, gina and hank only delegate
+	// to each other and ivan takes the delegation back
+	for _, d := range [][3]string{
+		{"bob", "alice", ""}, {"carol", "alice", ""}, {"carol", "dave", "budget"},
+		{"erin", "alice", ""}, {"frank", "bob", ""}, {"gina", "hank", ""},
+		{"hank", "gina", ""}, {"ivan", "alice", ""}, {"ivan", "", ""},
+	} {
+		res = delegate(d[0], d[1], d[2])
+		assert.True(res.IsOK(), res.String())
+	}
+	delegators, _ := getDelegators(p2v.prefix(store), "", addr("alice"))
+	assert.Equal([][]byte{addr("bob"), addr("carol"), addr("erin")}, delegators)
+	delegators, _ = getDelegators(p2v.prefix(store), "budget", addr("dave"))
+	assert.Equal([][]byte{addr("carol")}, delegators)
+
+	vote("alice", VoteTx{Issue: "budget", VoteTypeByte: TypeByteVoteFor})
+	vote("dave", VoteTx{Issue: "budget", VoteTypeByte: TypeByteVoteAgainst})
+	vote("erin", VoteTx{Issue: "budget", VoteTypeByte: TypeByteVoteAgainst})
+	vote("alice", VoteTx{Issue: "chair", Ranking: []string{"ben", "ann"}})
+	vote("dave", VoteTx{Issue: "chair", Ranking: []string{"ann"}})
+
+	// delegations are only counted when the issue closes
+	p2vIssue, _ := getIssue(p2v.prefix(store), "budget")
+	assert.Equal(1, p2vIssue.VotesFor)
+	p2v.EndBlock(store, 5)
+	p2vIssue, _ = getIssue(p2v.prefix(store), "budget")
+	assert.Equal(3, p2vIssue.VotesFor, "alice, bob and frank")
+	assert.Equal(3, p2vIssue.VotesAgainst, "dave, erin and carol")
+	assert.Equal(3, p2vIssue.Delegated)
+
+	p2vIssue, _ = getIssue(p2v.prefix(store), "chair")
+	assert.Equal(StatusPassed, p2vIssue.Status)
+	assert.Equal("ben", p2vIssue.Winner)
+	assert.Equal(6, p2vIssue.VotesCast(), "carol and erin follow alice on the chair")
+	assert.True(delegate("carol", "alice", "chair").IsErr(), "issue has closed")
+}
+
+func TestDelegationFees(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	treasury := []byte("treasury-address-20b")
+	p2v.SetOption(store, OptionBeneficiary, cmn.Fmt("%X", treasury))
+	fee := types.Coins{{"voteToken", 2}}
+	addr := func(name string) []byte {
+		return []byte(cmn.Fmt("%-20s", name))
+	}
+	balance := func(name string) types.Coins { return state.GetAccount(store, addr(name)).Balance }
+
+	res := runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:      "budget",
+		FeePerVote: fee,
+		EndHeight:  5,
+	}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, []byte("creator"), nil, NewCreateIssueTxBytesFrom(CreateIssueTx{
+		Issue:       "grant",
+		EndHeight:   6,
+		Mode:        ModeWeighted,
+		WeightDenom: "stake",
+	}))
+	assert.True(res.IsOK(), res.String())
+
+	// bob can pay for his vote, carol cannot, the sybils hold nothing and
+	// dan's delegate never votes
+	accounts := map[string]types.Coins{
+		"alice": {{"stake", 5}, {"voteToken", 10}},
+		"bob":   {{"stake", 7}, {"voteToken", 5}},
+		"carol": {{"stake", 9}, {"voteToken", 1}},
+		"dan":   {{"voteToken", 5}},
+	}
+	for name, coins := range accounts {
+		state.SetAccount(store, addr(name), &types.Account{Balance: coins})
+	}
+	for _, d := range [][2]string{{"bob", "alice"}, {"carol", "alice"}, {"dan", "erin"}, {"sybil1", "alice"}, {"sybil2", "sybil1"}} {
+		res = runTx(p2v, store, addr(d[0]), nil, NewDelegateTxBytes(DelegateTx{Delegate: addr(d[1])}))
+		assert.True(res.IsOK(), res.String())
+	}
+	res = runTx(p2v, store, addr("alice"), fee, NewVoteTxBytes("budget", TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, addr("alice"), types.Coins{{"stake", 5}}, NewVoteTxBytes("grant", TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+
+	// delegators pay the vote fee, or are not counted
+	p2v.EndBlock(store, 5)
+	p2vIssue, _ := getIssue(p2v.prefix(store), "budget")
+	assert.Equal(2, p2vIssue.VotesFor, "alice and bob")
+	assert.Equal(1, p2vIssue.Delegated)
+	assert.True(p2vIssue.FeesCollected.IsEqual(types.Coins{{"voteToken", 4}}))
+	assert.True(balance("bob").IsEqual(types.Coins{{"stake", 7}, {"voteToken", 3}}))
+	assert.True(balance("carol").IsEqual(accounts["carol"]), "cannot pay")
+	assert.True(balance("dan").IsEqual(accounts["dan"]), "delegate did not vote")
+	assert.True(state.GetAccount(store, treasury).Balance.IsEqual(types.Coins{{"stake", 5}, {"voteToken", 4}}))
+	voters, _ := getVoters(p2v.prefix(store), "budget")
+	assert.Equal([][]byte{addr("alice"), addr("bob")}, voters)
+
+	// a free weighted vote weighs what the delegator holds, which they
+	// pay like the weight of a direct vote
+	p2v.EndBlock(store, 6)
+	p2vIssue, _ = getIssue(p2v.prefix(store), "grant")
+	assert.Equal(types.Coin{"stake", 21}, p2vIssue.WeightFor, "alice, bob and carol")
+	assert.Equal(3, p2vIssue.VotesFor)
+	assert.True(p2vIssue.FeesCollected.IsEqual(types.Coins{{"stake", 21}}))
+	assert.True(balance("bob").IsEqual(types.Coins{{"voteToken", 3}}))
+	assert.True(balance("carol").IsEqual(types.Coins{{"voteToken", 1}}))
+	assert.True(state.GetAccount(store, treasury).Balance.IsEqual(types.Coins{{"stake", 21}, {"voteToken", 4}}))
+}
+
+func TestPluginNamespaces(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	board, community, p2v := New("board"), New("community"), New(LegacyName)
+
+	// issues created before the plugin name was used are stored without a prefix
+	store.Set(IssueKey("legacy"), wire.BinaryBytes(newP2VIssue(CreateIssueTx{Issue: "legacy", EndHeight: 5})))
+	store.Set(ClosingKey(5), wire.BinaryBytes([]string{"legacy"}))
+
+	for _, plugin := range []*P2VPlugin{board, community} {
+		res := runTx(plugin, store, []byte("creator"), nil,
+			NewCreateIssueTxBytesFrom(CreateIssueTx{Issue: "budget"}))
+		assert.True(res.IsOK(), res.String())
+		_, err := getIssue(plugin.prefix(store), "legacy")
+		assert.NotNil(err, "only the legacy plugin moves the old state")
+	}
+	res := runTx(board, store, []byte("voter"), nil,
+		NewVoteTxBytesFrom(VoteTx{Issue: "budget", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsOK(), res.String())
+	issue, _ := getIssue(board.prefix(store), "budget")
+	assert.Equal(1, issue.VotesFor)
+	issue, _ = getIssue(community.prefix(store), "budget")
+	assert.Equal(0, issue.VotesFor)
+
+	// the legacy plugin moves each key under its prefix when first read
+	res = runTx(p2v, store, []byte("voter"), nil,
+		NewVoteTxBytesFrom(VoteTx{Issue: "legacy", VoteTypeByte: TypeByteVoteFor}))
+	assert.True(res.IsOK(), res.String())
+	assert.Empty(store.Get(IssueKey("legacy")))
+	assert.NotEmpty(store.Get(PrefixedKey(LegacyName, IssueKey("legacy"))))
+	p2v.EndBlock(store, 5)
+	assert.Empty(store.Get(ClosingKey(5)))
+	issue, _ = getIssue(p2v.prefix(store), "legacy")
+	assert.Equal(StatusPassed, issue.Status)
+}
+
+func TestLegacyIssues(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New(LegacyName)
+	voter := []byte("voter")
+	fee := types.Coins{{"voteToken", 1}}
+	state.SetAccount(store, voter, &types.Account{Balance: types.Coins{{"voteToken", 5}}})
+
+	// issues written before the issue options were added still load
+	legacy := wire.BinaryBytes(legacyP2VIssue{
+		Issue:      "baseline",
+		FeePerVote: fee,
+		VotesFor:   2,
+	})
+	decoded, err := DecodeIssue(legacy)
+	if assert.Nil(err) {
+		assert.Equal("baseline", decoded.Issue)
+		assert.Equal(2, decoded.VotesFor)
+	}
+	_, err = DecodeIssue([]byte{0xFF})
+	assert.NotNil(err)
+	store.Set(IssueKey("baseline"), legacy)
+	res := runTx(p2v, store, voter, fee, NewVoteTxBytes("baseline", TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+	issue, err := getIssue(p2v.prefix(store), "baseline")
+	if assert.Nil(err) {
+		assert.Equal(2, issue.VotesFor)
+		assert.Equal(1, issue.VotesAgainst)
+		assert.Equal(StatusOpen, issue.Status)
+	}
+
+	// an issue which cannot be read is tried again in the next block
+	// instead of halting the chain
+	res = runTx(p2v, store, voter, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{Issue: "good", EndHeight: 5}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(p2v, store, voter, nil, NewCreateIssueTxBytesFrom(CreateIssueTx{Issue: "later", EndHeight: 6}))
+	assert.True(res.IsOK(), res.String())
+	p2v.prefix(store).Set(IssueKey("broken"), []byte{0xFF})
+	p2v.prefix(store).Set(ClosingKey(5), wire.BinaryBytes([]string{"broken", "good"}))
+	assert.NotPanics(func() { p2v.EndBlock(store, 5) })
+	closing, err := getClosing(p2v.prefix(store), 5)
+	assert.Nil(err)
+	assert.Empty(closing)
+	closing, err = getClosing(p2v.prefix(store), 6)
+	assert.Nil(err)
+	assert.Equal([]string{"later", "broken"}, closing)
+	issue, _ = getIssue(p2v.prefix(store), "good")
+	assert.Equal(StatusRejected, issue.Status)
+
+	// once repaired it closes
+	p2v.prefix(store).Set(IssueKey("broken"), wire.BinaryBytes(P2VIssue{Issue: "broken", EndHeight: 5}))
+	p2v.EndBlock(store, 6)
+	closing, err = getClosing(p2v.prefix(store), 7)
+	assert.Nil(err)
+	assert.Empty(closing)
+	issue, _ = getIssue(p2v.prefix(store), "broken")
+	assert.Equal(StatusRejected, issue.Status)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	store.Set([]byte("a"), []byte("1"))
+
+	buf := newWriteBuffer(store)
+	buf.Set([]byte("a"), []byte("2"))
+	buf.Set([]byte("b"), []byte("3"))
+	assert.Equal([]byte("2"), buf.Get([]byte("a")))
+	assert.Equal([]byte("3"), buf.Get([]byte("b")))
+	assert.Equal([]byte("1"), store.Get([]byte("a")))
+	assert.Empty(store.Get([]byte("b")))
+
+	buf.flush()
+	assert.Equal([]byte("2"), store.Get([]byte("a")))
+	assert.Equal([]byte("3"), store.Get([]byte("b")))
+}
+
+func TestIssueMetadata(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v := New("paytovote")
+	proposer := []byte("proposer")
+	hash := sha256.Sum256([]byte("the full proposal"))
+
+	assert.Contains(p2v.SetOption(store, OptionMaxDescriptionLength, "100000"), "Invalid")
+	assert.Equal("Set max-description-length: 16", p2v.SetOption(store, OptionMaxDescriptionLength, "16"))
+
+	create := func(tx CreateIssueTx) abci.Result {
+		return runTx(p2v, store, proposer, nil, NewCreateIssueTxBytesFrom(tx))
+	}
+	res := create(CreateIssueTx{Issue: "long", Description: "more than sixteen bytes"})
+	assert.True(res.IsErr(), "long description")
+	res = create(CreateIssueTx{Issue: "hash", ContentHash: make([]byte, maxContentHashLength+1)})
+	assert.True(res.IsErr(), "long content hash")
+
+	p2v.BeginBlock(store, 42)
+	res = create(CreateIssueTx{
+		Issue:       "roof",
+		Description: "Fix the roof",
+		Link:        "https://example.com/roof",
+		ContentHash: hash[:],
+	})
+	assert.True(res.IsOK(), res.String())
+	issue, err := getIssue(p2v.prefix(store), "roof")
+	assert.Nil(err)
+	assert.Equal(proposer, issue.Proposer)
+	assert.Equal(uint64(42), issue.CreatedHeight)
+	assert.Equal("Fix the roof", issue.Description)
+	assert.Equal("https://example.com/roof", issue.Link)
+	assert.Equal(hash[:], issue.ContentHash)
+}
+
+func TestIssueList(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	p2v, other := New("paytovote"), New("other")
+	create := func(plugin *P2VPlugin, issue string) abci.Result {
+		return runTx(plugin, store, []byte("creator"), nil, NewCreateIssueTxBytes(issue, nil, nil))
+	}
+
+	// issues created before the list was kept are not listed
+	p2v.prefix(store).Set(IssueKey("early"), wire.BinaryBytes(newP2VIssue(CreateIssueTx{Issue: "early"})))
+	issues, err := getIssues(p2v.prefix(store))
+	assert.Nil(err)
+	assert.Empty(issues)
+
+	// issues are listed in the order they were created, once
+	for _, issue := range []string{"zoning", "budget", "parks"} {
+		res := create(p2v, issue)
+		assert.True(res.IsOK(), res.String())
+	}
+	assert.True(create(p2v, "budget").IsErr(), "already exists")
+	assert.True(create(p2v, "early").IsErr(), "already exists")
+	assert.True(create(other, "library").IsOK())
+	issues, err = getIssues(p2v.prefix(store))
+	assert.Nil(err)
+	assert.Equal([]string{"zoning", "budget", "parks"}, issues)
+
+	// the early issue can still be read by name, and each plugin keeps its own list
+	_, err = getIssue(p2v.prefix(store), "early")
+	assert.Nil(err)
+	issues, err = getIssues(other.prefix(store))
+	assert.Nil(err)
+	assert.Equal([]string{"library"}, issues)
+}
@@ -0,0 +1,162 @@
+package paytovote
+
+import (
+	"github.com/tendermint/basecoin/types"
+	cmn "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+)
+
+// Ranking is one ballot on a ranked issue, kept until the issue closes
+// so the instant-runoff can be counted
+type Ranking struct {
+	Voter   []byte
+	Ranking []string //Choices in order of preference
+	Votes   int
+	Weight  int64 //Weight of the ballot in ModeWeighted
+}
+
+// Round is one count of an instant-runoff: the tally of the first
+// preference among the remaining choices on every ballot, after
+// which the choices with the fewest votes are eliminated
+type Round struct {
+	Tallies    []Choice
+	Eliminated []string
+}
+
+// RankingKey is where the n-th ballot on a ranked issue is stored
+func RankingKey(issue string, n int) []byte {
+	return []byte(cmn.Fmt("P2VPlugin{issue=%v,ranking=%v}.State", issue, n))
+}
+
+func getRanking(store types.KVStore, issue string, n int) (ranking Ranking, err error) {
+	rankingBytes := store.Get(RankingKey(issue, n))
+	if len(rankingBytes) > 0 {
+		err = wire.ReadBinaryBytes(rankingBytes, &ranking)
+	}
+	return
+}
+
+func getRankings(store types.KVStore, p2vIssue P2VIssue) ([]Ranking, error) {
+	rankings := make([]Ranking, p2vIssue.Rankings)
+	for i := range rankings {
+		var err error
+		rankings[i], err = getRanking(store, p2vIssue.Issue, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rankings, nil
+}
+
+// addRanking stores a new ballot on a ranked issue
+func addRanking(store types.KVStore, p2vIssue *P2VIssue, p2vVoter *P2VVoter, voter []byte, ranking []string, votes int, weight int64) {
+	store.Set(RankingKey(p2vIssue.Issue, p2vIssue.Rankings), wire.BinaryBytes(Ranking{voter, ranking, votes, weight}))
+	p2vIssue.Rankings += 1
+	p2vVoter.Ranking = p2vIssue.Rankings
+}
+
+// validRanking requires distinct choices of the issue, where
+// abstaining is only possible by ranking nothing else
+func validRanking(choices []Choice, ranking []string) bool {
+	if len(ranking) == 0 || len(ranking) > len(choices) {
+		return false
+	}
+	if len(ranking) > 1 && findString(ranking, AbstainChoice) >= 0 {
+		return false
+	}
+	for i, name := range ranking {
+		if findChoice(choices, name) < 0 || findString(ranking[:i], name) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRanking(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func findString(strs []string, str string) int {
+	for i, s := range strs {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
+
+// runoff counts an instant-runoff between the choices until one has a
+// majority of the ballots still ranking a remaining choice. All choices
+// tied for the fewest votes are eliminated together, so there is no
+// winner if the last ones standing are tied.
+func runoff(choices []Choice, rankings []Ranking, weighted bool) (winner string, leading, votes int64, rounds []Round) {
+	eliminated := map[string]bool{AbstainChoice: true}
+	for {
+		round := Round{Tallies: []Choice{}}
+		for _, choice := range choices {
+			if !eliminated[choice.Name] {
+				round.Tallies = append(round.Tallies, Choice{Name: choice.Name})
+			}
+		}
+
+		// Count each ballot for its most preferred remaining choice
+		for _, ranking := range rankings {
+			for _, name := range ranking.Ranking {
+				if eliminated[name] {
+					continue
+				}
+				i := findChoice(round.Tallies, name)
+				round.Tallies[i].Votes += ranking.Votes
+				round.Tallies[i].Weight += ranking.Weight
+				break
+			}
+		}
+
+		winner, leading, votes = "", 0, 0
+		lowest := int64(-1)
+		for _, choice := range round.Tallies {
+			tally := choice.tally(weighted)
+			votes += tally
+			if tally > leading {
+				winner, leading = choice.Name, tally
+			}
+			if lowest < 0 || tally < lowest {
+				lowest = tally
+			}
+		}
+		if votes > 0 && 2*leading > votes {
+			rounds = append(rounds, round)
+			return winner, leading, votes, rounds
+		}
+
+		for _, choice := range round.Tallies {
+			if choice.tally(weighted) == lowest {
+				round.Eliminated = append(round.Eliminated, choice.Name)
+				eliminated[choice.Name] = true
+			}
+		}
+		rounds = append(rounds, round)
+		if len(round.Eliminated) == len(round.Tallies) {
+			return "", leading, votes, rounds
+		}
+	}
+}
+
+// closeRanked records the outcome of the instant-runoff and its rounds
+func (p *P2VIssue) closeRanked(rankings []Ranking) {
+	if p.VotesCast() < p.Quorum {
+		p.Status = StatusNoQuorum
+		return
+	}
+	winner, leading, votes, rounds := runoff(p.Choices, rankings, p.Mode == ModeWeighted)
+	p.Rounds = rounds
+	p.elect(winner, leading, votes)
+}
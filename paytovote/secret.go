@@ -0,0 +1,139 @@
+package paytovote
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+// RevealTx discloses the ballot behind a commitment on a secret issue.
+// The voter commits to it while voting is open by sending the hash from
+// Commitment in a VoteTx, then sends the RevealTx in the reveal phase.
+type RevealTx struct {
+	Issue        string   //Issue being voted for
+	VoteTypeByte byte     //How is the vote being cast
+	Choice       string   //Choice being voted for with TypeByteVoteChoice
+	Ranking      []string //Choices in order of preference on a ranked issue
+	Salt         []byte   //Secret which keeps the ballot from being guessed
+}
+
+func NewRevealTxBytes(tx RevealTx) []byte {
+	data := wire.BinaryBytes(tx)
+	data = append([]byte{TypeByteTxReveal}, data...)
+	return data
+}
+
+// Commitment is the hash the voter commits to in the VoteTx. It covers
+// the address of the voter, so no one else can commit to the same hash
+// and reveal the ballot in their place.
+func (tx RevealTx) Commitment(voter []byte) []byte {
+	data := append(wire.BinaryBytes(voter), wire.BinaryBytes(tx)...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// IsSecret is true if ballots are committed to while voting is
+// open and only counted once revealed, up to the reveal height
+func (p P2VIssue) IsSecret() bool {
+	return p.RevealHeight != 0
+}
+
+// IsRevealing is true while the ballots of a secret issue may be revealed
+func (p P2VIssue) IsRevealing(height uint64) bool {
+	return p.IsSecret() && p.Status == StatusOpen &&
+		height > p.EndHeight && height <= p.RevealHeight
+}
+
+// ClosesAt is the height at the end of which the outcome is decided
+func (p P2VIssue) ClosesAt() uint64 {
+	if p.IsSecret() {
+		return p.RevealHeight
+	}
+	return p.EndHeight
+}
+
+// IsUnrevealed is true if the voter committed to a ballot but never disclosed it
+func (v P2VVoter) IsUnrevealed() bool {
+	return len(v.Commitment) > 0 && !v.Revealed
+}
+
+func (p2v *P2VPlugin) runTxReveal(store, accts types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+
+	// Decode tx
+	var tx RevealTx
+	err := wire.ReadBinaryBytes(txBytes, &tx)
+	if err != nil {
+		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
+	}
+
+	// Load P2VIssue and the committed ballot
+	p2vIssue, err := getIssue(store, tx.Issue)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
+	}
+	if !p2vIssue.IsRevealing(p2v.height) {
+		return abci.ErrInternalError.AppendLog("Ballots on this issue cannot be revealed now")
+	}
+	p2vVoter, err := getVoter(store, tx.Issue, ctx.CallerAddress)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	switch {
+	case len(p2vVoter.Commitment) == 0:
+		return abci.ErrUnauthorized.AppendLog("No ballot was committed to on this issue")
+	case p2vVoter.Revealed:
+		return abci.ErrInternalError.AppendLog("Ballot has already been revealed")
+	case !bytes.Equal(p2vVoter.Commitment, tx.Commitment(ctx.CallerAddress)):
+		return abci.ErrUnauthorized.AppendLog("Ballot does not match the commitment")
+	}
+
+	voteTypeByte, choice := tx.VoteTypeByte, tx.Choice
+	if p2vIssue.Ranked {
+		if !validRanking(p2vIssue.Choices, tx.Ranking) {
+			return abci.ErrInternalError.AppendLog("P2VTx.Ranking must be distinct choices of the issue")
+		}
+		voteTypeByte, choice = TypeByteVoteChoice, tx.Ranking[0]
+	} else if len(tx.Ranking) > 0 {
+		return abci.ErrInternalError.AppendLog("P2VTx.Ranking is only for ranked issues")
+	}
+
+	// Count the votes paid for when committing
+	votes, weight := p2vVoter.SealedVotes, p2vVoter.SealedWeight
+	if !p2vIssue.addVotes(voteTypeByte, choice, votes, weight) {
+		return abci.ErrInternalError.AppendLog("P2VTx.VoteTypeByte or Choice was not recognized")
+	}
+	p2vVoter.addVotes(voteTypeByte, choice, votes, weight)
+	if p2vIssue.Ranked {
+		addRanking(store, &p2vIssue, &p2vVoter, ctx.CallerAddress, tx.Ranking, votes, weight)
+	}
+	p2vVoter.Revealed = true
+
+	// Save P2VIssue and P2VVoter, revealing is free
+	store.Set(VoterKey(tx.Issue, ctx.CallerAddress), wire.BinaryBytes(p2vVoter))
+	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
+	chargeFee(accts, ctx, nil, nil)
+	return abci.OK
+}
+
+// countForfeits records the ballots of a secret issue which were never
+// revealed, and the vote fees paid for them which are never refunded
+func countForfeits(store types.KVStore, p2vIssue *P2VIssue) error {
+	voters, err := getVoters(store, p2vIssue.Issue)
+	if err != nil {
+		return err
+	}
+	for _, voter := range voters {
+		p2vVoter, err := getVoter(store, p2vIssue.Issue, voter)
+		if err != nil {
+			return err
+		}
+		if p2vVoter.IsUnrevealed() {
+			p2vIssue.Unrevealed += 1
+			p2vIssue.Forfeited = p2vIssue.Forfeited.Plus(p2vVoter.Paid)
+		}
+	}
+	return nil
+}
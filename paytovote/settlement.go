@@ -0,0 +1,118 @@
+package paytovote
+
+import (
+	"github.com/tendermint/basecoin/types"
+	cmn "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+)
+
+// VotersKey is where the addresses of everyone who voted on an issue
+// are stored, followed by the delegators counted when it closed
+func VotersKey(issue string) []byte {
+	return []byte(cmn.Fmt("P2VPlugin{issue=%v}.Voters", issue))
+}
+
+func getVoters(store types.KVStore, issue string) (voters [][]byte, err error) {
+	votersBytes := store.Get(VotersKey(issue))
+	if len(votersBytes) > 0 {
+		err = wire.ReadBinaryBytes(votersBytes, &voters)
+	}
+	return
+}
+
+// IsHeld is true if vote fees are held until the issue closes
+func (p P2VIssue) IsHeld() bool {
+	return p.Settlement != SettleNone
+}
+
+// voteFeePayee is who the vote fees are paid to as they come in,
+// none if they are burned or held by the issue
+func (p P2VIssue) voteFeePayee(config P2VConfig) []byte {
+	if p.IsHeld() {
+		return nil
+	}
+	return p.FeeBeneficiary(config)
+}
+
+// won is true if the ballot of the voter is on the side of the outcome:
+// for a passed issue, or the winning choice, or against a rejected issue
+func (p P2VIssue) won(p2vVoter P2VVoter) bool {
+	voteTypeByte, choice, votes, _ := p2vVoter.ballot()
+	switch {
+	case votes == 0:
+		return false
+	case p.Status == StatusPassed && len(p.Choices) > 0:
+		return voteTypeByte == TypeByteVoteChoice && choice == p.Winner
+	case p.Status == StatusPassed:
+		return voteTypeByte == TypeByteVoteFor
+	case p.Status == StatusRejected && len(p.Choices) == 0:
+		return voteTypeByte == TypeByteVoteAgainst
+	}
+	return false
+}
+
+// refunded is true if the voter gets back what they paid
+func (p P2VIssue) refunded(p2vVoter P2VVoter) bool {
+	switch {
+	case p2vVoter.IsUnrevealed():
+		return false
+	case p.Status == StatusNoQuorum:
+		return true
+	case p.Settlement == SettleRefundLosers:
+		return !p.won(p2vVoter)
+	case p.Settlement == SettleRefundWinners:
+		return p.won(p2vVoter)
+	}
+	return false
+}
+
+// settle pays back the held vote fees of the voters to be refunded
+// and the rest to the beneficiary of the closed issue
+func settle(store, accts types.KVStore, p2vIssue *P2VIssue) error {
+	config, err := getConfig(store)
+	if err != nil {
+		return err
+	}
+	voters, err := getVoters(store, p2vIssue.Issue)
+	if err != nil {
+		return err
+	}
+
+	// read every voter before paying anyone, so a failure pays no one
+	var refundTo [][]byte
+	var refunds []types.Coins
+	var refunded types.Coins
+	for _, voter := range voters {
+		p2vVoter, err := getVoter(store, p2vIssue.Issue, voter)
+		if err != nil {
+			return err
+		}
+		if p2vVoter.Paid.IsZero() || !p2vIssue.refunded(p2vVoter) {
+			continue
+		}
+		refundTo = append(refundTo, voter)
+		refunds = append(refunds, p2vVoter.Paid)
+		refunded = refunded.Plus(p2vVoter.Paid)
+	}
+	for i, voter := range refundTo {
+		pay(accts, voter, refunds[i])
+	}
+	p2vIssue.Refunded = refunded
+
+	remainder := p2vIssue.FeesCollected.Minus(refunded)
+	beneficiary := p2vIssue.FeeBeneficiary(config)
+	if len(beneficiary) > 0 && !remainder.IsZero() {
+		pay(accts, beneficiary, remainder)
+	}
+	return nil
+}
+
+// addVoter records a new voter on an issue
+func addVoter(store types.KVStore, issue string, voter []byte) error {
+	voters, err := getVoters(store, issue)
+	if err != nil {
+		return err
+	}
+	store.Set(VotersKey(issue), wire.BinaryBytes(append(voters, voter)))
+	return nil
+}
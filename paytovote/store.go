@@ -0,0 +1,82 @@
+package paytovote
+
+import (
+	"github.com/tendermint/basecoin/types"
+)
+
+// LegacyName is the name every plugin had before state was kept under
+// the plugin name. Only the plugin by this name takes over the keys
+// stored without a prefix.
+const LegacyName = "paytovote"
+
+// PrefixedKey is where a key of the plugin by the given name is stored
+func PrefixedKey(name string, key []byte) []byte {
+	prefixed := make([]byte, 0, len(name)+1+len(key))
+	prefixed = append(prefixed, name...)
+	prefixed = append(prefixed, '/')
+	return append(prefixed, key...)
+}
+
+// prefixStore keeps the state of one plugin apart from other plugins
+// and the accounts, under its name
+type prefixStore struct {
+	store  types.KVStore
+	name   string
+	legacy bool //Move keys stored without a prefix under it when first read
+}
+
+func (p prefixStore) Set(key, value []byte) {
+	p.store.Set(PrefixedKey(p.name, key), value)
+}
+
+func (p prefixStore) Get(key []byte) []byte {
+	value := p.store.Get(PrefixedKey(p.name, key))
+	if len(value) == 0 && p.legacy {
+		value = p.store.Get(key)
+		if len(value) > 0 {
+			p.store.Set(PrefixedKey(p.name, key), value)
+			p.store.Set(key, nil)
+		}
+	}
+	return value
+}
+
+// prefix lets us store all our state in a separate name-space, while
+// the accounts stay in the store we are given
+func (p2v *P2VPlugin) prefix(store types.KVStore) types.KVStore {
+	return prefixStore{store, p2v.name, p2v.name == LegacyName}
+}
+
+// writeBuffer holds back the writes to a store until they are flushed,
+// while reads see them, so a change failing half way writes nothing
+type writeBuffer struct {
+	store  types.KVStore
+	keys   []string //In the order first written
+	values map[string][]byte
+}
+
+func newWriteBuffer(store types.KVStore) *writeBuffer {
+	return &writeBuffer{store: store, values: make(map[string][]byte)}
+}
+
+func (w *writeBuffer) Set(key, value []byte) {
+	k := string(key)
+	if _, ok := w.values[k]; !ok {
+		w.keys = append(w.keys, k)
+	}
+	w.values[k] = value
+}
+
+func (w *writeBuffer) Get(key []byte) []byte {
+	if value, ok := w.values[string(key)]; ok {
+		return value
+	}
+	return w.store.Get(key)
+}
+
+// flush writes everything held back to the store
+func (w *writeBuffer) flush() {
+	for _, k := range w.keys {
+		w.store.Set([]byte(k), w.values[k])
+	}
+}